@@ -17,13 +17,30 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"flag"
+
 	// The set of controllers this controller process runs.
 	"knative.dev/net-contour/pkg/reconciler/contour"
 
 	// This defines the shared main for injected controllers.
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
 	"knative.dev/pkg/injection/sharedmain"
 )
 
+var (
+	targetNamespace = flag.String("target-namespace", "",
+		"When set, only reconcile Ingresses in this namespace.")
+	proxyCreateWorkers = flag.Int("proxy-create-workers", contour.DefaultProxyWorkers,
+		"How many HTTPProxies to create or update concurrently for an Ingress.")
+)
+
 func main() {
-	sharedmain.Main("net-contour-controller", contour.NewController)
+	// sharedmain parses the flags before calling the constructor.
+	sharedmain.Main("net-contour-controller", func(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+		ctx = contour.WithTargetNamespace(ctx, *targetNamespace)
+		ctx = contour.WithProxyWorkers(ctx, *proxyCreateWorkers)
+		return contour.NewController(ctx, cmw)
+	})
 }
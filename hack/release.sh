@@ -24,6 +24,7 @@ declare -A COMPONENTS
 COMPONENTS=(
   ["net-contour.yaml"]="config"
   ["contour.yaml"]="config/contour"
+  ["net-contour-auto-delegate-certificates.yaml"]="config/auto-delegate-certificates"
 )
 readonly COMPONENTS
 
@@ -36,9 +36,11 @@ const (
 
 	visibilityConfigKey = "visibility"
 	// nolint:gosec // Not an actual secret.
-	defaultTLSSecretConfigKey = "default-tls-secret"
-	timeoutPolicyIdleKey      = "timeout-policy-idle"
-	timeoutPolicyResponseKey  = "timeout-policy-response"
+	defaultTLSSecretConfigKey   = "default-tls-secret"
+	timeoutPolicyIdleKey        = "timeout-policy-idle"
+	timeoutPolicyResponseKey    = "timeout-policy-response"
+	autoDelegateCertificatesKey = "auto-delegate-certificates"
+	useFractionalWeightsKey     = "use-fractional-weights"
 )
 
 // Contour contains contour related configuration defined in the
@@ -49,6 +51,12 @@ type Contour struct {
 	DefaultTLSSecret      *types.NamespacedName
 	TimeoutPolicyResponse string
 	TimeoutPolicyIdle     string
+	// AutoDelegateCertificates controls whether TLSCertificateDelegations are
+	// created for TLS secrets that live outside of the Ingress's namespace.
+	AutoDelegateCertificates bool
+	// UseFractionalWeights scales split percentages by 1000 when setting
+	// service weights, for Contour versions that split on finer weights.
+	UseFractionalWeights bool
 }
 
 type visibilityValue struct {
@@ -61,11 +69,15 @@ func NewContourFromConfigMap(configMap *corev1.ConfigMap) (*Contour, error) {
 	var tlsSecret *types.NamespacedName
 	var timeoutPolicyResponse = "infinity"
 	var timeoutPolicyIdle = "infinity"
+	var autoDelegateCertificates bool
+	var useFractionalWeights bool
 
 	if err := configmap.Parse(configMap.Data,
 		configmap.AsOptionalNamespacedName(defaultTLSSecretConfigKey, &tlsSecret),
 		asContourDuration(timeoutPolicyResponseKey, &timeoutPolicyResponse),
 		asContourDuration(timeoutPolicyIdleKey, &timeoutPolicyIdle),
+		configmap.AsBool(autoDelegateCertificatesKey, &autoDelegateCertificates),
+		configmap.AsBool(useFractionalWeightsKey, &useFractionalWeights),
 	); err != nil {
 		return nil, err
 	}
@@ -83,8 +95,10 @@ func NewContourFromConfigMap(configMap *corev1.ConfigMap) (*Contour, error) {
 				v1alpha1.IngressVisibilityClusterLocal: "contour-internal",
 				v1alpha1.IngressVisibilityExternalIP:   "contour-external",
 			},
-			TimeoutPolicyResponse: timeoutPolicyResponse,
-			TimeoutPolicyIdle:     timeoutPolicyIdle,
+			TimeoutPolicyResponse:    timeoutPolicyResponse,
+			TimeoutPolicyIdle:        timeoutPolicyIdle,
+			AutoDelegateCertificates: autoDelegateCertificates,
+			UseFractionalWeights:     useFractionalWeights,
 		}, nil
 	}
 	entry := make(map[v1alpha1.IngressVisibility]visibilityValue)
@@ -102,11 +116,13 @@ func NewContourFromConfigMap(configMap *corev1.ConfigMap) (*Contour, error) {
 	}
 
 	contour := &Contour{
-		DefaultTLSSecret:      tlsSecret,
-		VisibilityKeys:        make(map[v1alpha1.IngressVisibility]sets.String, 2),
-		VisibilityClasses:     make(map[v1alpha1.IngressVisibility]string, 2),
-		TimeoutPolicyResponse: timeoutPolicyResponse,
-		TimeoutPolicyIdle:     timeoutPolicyIdle,
+		DefaultTLSSecret:         tlsSecret,
+		VisibilityKeys:           make(map[v1alpha1.IngressVisibility]sets.String, 2),
+		VisibilityClasses:        make(map[v1alpha1.IngressVisibility]string, 2),
+		TimeoutPolicyResponse:    timeoutPolicyResponse,
+		TimeoutPolicyIdle:        timeoutPolicyIdle,
+		AutoDelegateCertificates: autoDelegateCertificates,
+		UseFractionalWeights:     useFractionalWeights,
 	}
 	for key, value := range entry {
 		// Check that the visibility makes sense.
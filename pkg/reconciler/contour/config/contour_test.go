@@ -195,6 +195,80 @@ func TestTimeoutPolicyIdle(t *testing.T) {
 	}
 }
 
+func TestAutoDelegateCertificates(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: system.Namespace(),
+			Name:      ContourConfigName,
+		},
+		Data: map[string]string{},
+	}
+
+	cfg, err := NewContourFromConfigMap(cm)
+	if err != nil {
+		t.Error("NewContourFromConfigMap() =", err)
+	}
+
+	if cfg.AutoDelegateCertificates {
+		t.Error("AutoDelegateCertificates got true want false")
+	}
+
+	cm.Data["auto-delegate-certificates"] = "true"
+
+	cfg, err = NewContourFromConfigMap(cm)
+	if err != nil {
+		t.Error("NewContourFromConfigMap(auto-delegate-certificates:true) =", err)
+	}
+
+	if !cfg.AutoDelegateCertificates {
+		t.Error("AutoDelegateCertificates got false want true")
+	}
+
+	cm.Data["auto-delegate-certificates"] = "xyz"
+
+	_, err = NewContourFromConfigMap(cm)
+	if err == nil {
+		t.Errorf("expected an error parsing erroneous 'auto-delegate-certificates'")
+	}
+}
+
+func TestUseFractionalWeights(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: system.Namespace(),
+			Name:      ContourConfigName,
+		},
+		Data: map[string]string{},
+	}
+
+	cfg, err := NewContourFromConfigMap(cm)
+	if err != nil {
+		t.Error("NewContourFromConfigMap() =", err)
+	}
+
+	if cfg.UseFractionalWeights {
+		t.Error("UseFractionalWeights got true want false")
+	}
+
+	cm.Data["use-fractional-weights"] = "true"
+
+	cfg, err = NewContourFromConfigMap(cm)
+	if err != nil {
+		t.Error("NewContourFromConfigMap(use-fractional-weights:true) =", err)
+	}
+
+	if !cfg.UseFractionalWeights {
+		t.Error("UseFractionalWeights got false want true")
+	}
+
+	cm.Data["use-fractional-weights"] = "xyz"
+
+	_, err = NewContourFromConfigMap(cm)
+	if err == nil {
+		t.Errorf("expected an error parsing erroneous 'use-fractional-weights'")
+	}
+}
+
 func TestConfigurationErrors(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -17,18 +17,30 @@ limitations under the License.
 package contour
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
+	v1 "github.com/projectcontour/contour/apis/projectcontour/v1"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
 
+	corev1 "k8s.io/api/core/v1"
 	contourclientset "knative.dev/net-contour/pkg/client/clientset/versioned"
 	contourlisters "knative.dev/net-contour/pkg/client/listers/projectcontour/v1"
 	ingressclientset "knative.dev/networking/pkg/client/clientset/versioned"
@@ -41,17 +53,33 @@ import (
 	"knative.dev/networking/pkg/apis/networking"
 	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 	"knative.dev/networking/pkg/status"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/controller"
 	"knative.dev/pkg/kmp"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/network"
+	"knative.dev/pkg/ptr"
 	"knative.dev/pkg/reconciler"
 	"knative.dev/pkg/tracker"
 )
 
 const (
+	// FieldManager is the server-side apply field manager that owns the
+	// fields net-contour sets on the HTTPProxies it reconciles.
+	FieldManager = "net-contour"
+
 	// ContourIngressClassName value for specifying knative's Contour
 	// Ingress reconciler.
 	ContourIngressClassName = "contour.ingress.networking.knative.dev"
+
+	// InvalidAnnotationReason is the reason the Ingress is marked not ready
+	// when its annotations cannot be turned into HTTPProxies.
+	InvalidAnnotationReason = "InvalidAnnotation"
+
+	// CertificateDelegatedCondition is set to False, with warning severity,
+	// when the Ingress references a TLS secret in another namespace that has
+	// not been delegated to the Ingress's namespace.
+	CertificateDelegatedCondition apis.ConditionType = "CertificateDelegated"
 )
 
 // Reconciler implements controller.Reconciler for Ingress resources.
@@ -60,15 +88,21 @@ type Reconciler struct {
 	contourClient contourclientset.Interface
 
 	// Listers index properties about resources
-	contourLister contourlisters.HTTPProxyLister
-	ingressLister networkingv1alpha1.IngressLister
-	serviceLister corev1listers.ServiceLister
+	contourLister    contourlisters.HTTPProxyLister
+	delegationLister contourlisters.TLSCertificateDelegationLister
+	ingressLister    networkingv1alpha1.IngressLister
+	serviceLister    corev1listers.ServiceLister
 
 	statusManager status.Manager
 	tracker       tracker.Interface
+
+	// proxyWorkers bounds how many HTTPProxies are created or updated
+	// concurrently; values below one mean one at a time.
+	proxyWorkers int
 }
 
 var _ ingressreconciler.Interface = (*Reconciler)(nil)
+var _ reconciler.OnDeletionInterface = (*Reconciler)(nil)
 
 // ReconcileKind reconciles ingress resource.
 func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) reconciler.Event {
@@ -119,22 +153,28 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) r
 
 		desiredChIng := resources.MakeEndpointProbeIngress(ctx, ing, oldGeneration)
 		actualChIng, err := r.ingressLister.Ingresses(desiredChIng.Namespace).Get(desiredChIng.Name)
+		// Whether the probe already matched, so that its status is current.
+		probeUnchanged := true
 		if apierrs.IsNotFound(err) { // Create it.
 			actualChIng, err = r.ingressClient.NetworkingV1alpha1().Ingresses(desiredChIng.Namespace).Create(ctx, desiredChIng, metav1.CreateOptions{})
 			if err != nil {
 				return err
 			}
 			logger.Debugf("Created endpoint probe: %#v", actualChIng.Spec)
+			probeUnchanged = false
 		} else if err != nil {
 			return err
-		} else if !equality.Semantic.DeepEqual(actualChIng.Spec, desiredChIng.Spec) { // Reconcile it.
+		} else if !equality.Semantic.DeepEqual(actualChIng.Spec, desiredChIng.Spec) ||
+			!equality.Semantic.DeepEqual(actualChIng.Annotations, desiredChIng.Annotations) { // Reconcile it.
 			original := actualChIng
 			actualChIng = original.DeepCopy()
+			actualChIng.Annotations = desiredChIng.Annotations
 			actualChIng.Spec = desiredChIng.Spec
 			actualChIng, err = r.ingressClient.NetworkingV1alpha1().Ingresses(actualChIng.Namespace).Update(ctx, actualChIng, metav1.UpdateOptions{})
 			if err != nil {
 				return err
 			}
+			probeUnchanged = false
 			if diff, err := kmp.SafeDiff(actualChIng.Spec, original.Spec); err == nil {
 				logger.Debugf("Updated endpoint probe: %s", diff)
 			} else {
@@ -142,6 +182,14 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) r
 			}
 		}
 
+		if cond := actualChIng.Status.GetCondition(v1alpha1.IngressConditionReady); probeUnchanged &&
+			cond != nil && cond.Reason == InvalidAnnotationReason {
+			// The probe carries our annotations, so it was rejected for the
+			// same reason we would be.
+			ing.Status.MarkIngressNotReady(InvalidAnnotationReason, cond.Message)
+			return controller.NewPermanentError(errors.New(cond.Message))
+		}
+
 		if !actualChIng.IsReady() {
 			// This won't be toggled back until probing has completed.
 			ing.Status.MarkLoadBalancerNotReady()
@@ -204,41 +252,31 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) r
 		}
 	}
 
-	for _, proxy := range resources.MakeHTTPProxies(ctx, ing, serviceToProtocol) {
-		selector := labels.Set(map[string]string{
-			resources.ParentKey:     proxy.Labels[resources.ParentKey],
-			resources.DomainHashKey: proxy.Labels[resources.DomainHashKey],
-			resources.ClassKey:      proxy.Labels[resources.ClassKey],
-		}).AsSelector()
-		matches, err := r.contourLister.HTTPProxies(ing.Namespace).List(selector)
-		if err != nil {
-			return err
-		}
-		if len(matches) == 0 {
-			proxy, err := r.contourClient.ProjectcontourV1().HTTPProxies(proxy.Namespace).Create(ctx, proxy, metav1.CreateOptions{})
-			if err != nil {
+	proxies, err := resources.MakeHTTPProxies(ctx, ing, serviceToProtocol)
+	if err != nil {
+		// Retrying won't help until the user fixes the Ingress, which
+		// triggers another reconcile.
+		ing.Status.MarkIngressNotReady(InvalidAnnotationReason, err.Error())
+		return controller.NewPermanentError(err)
+	}
+	// Create or update the proxies concurrently, since Ingresses with many
+	// (expanded) hosts can produce a lot of them.
+	actualProxies := make([]*v1.HTTPProxy, len(proxies))
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(r.proxyWorkerLimit())
+	for i, proxy := range proxies {
+		i, proxy := i, proxy
+		eg.Go(func() (err error) {
+			// Skip the proxies still waiting for a worker once one failed.
+			if err := egCtx.Err(); err != nil {
 				return err
 			}
-			logger.Debugf("Created http proxy: %#v", proxy)
-			continue
-		}
-		update := matches[0].DeepCopy()
-		update.Annotations = proxy.Annotations
-		update.Labels = proxy.Labels
-		update.Spec = proxy.Spec
-		if equality.Semantic.DeepEqual(matches[0], update) {
-			// Avoid updates that don't change anything.
-			continue
-		}
-		if _, err = r.contourClient.ProjectcontourV1().HTTPProxies(proxy.Namespace).Update(ctx, update, metav1.UpdateOptions{}); err != nil {
+			actualProxies[i], err = r.reconcileProxy(egCtx, proxy)
 			return err
-		}
-		if diff, err := kmp.SafeDiff(update, matches[0]); err == nil {
-			logger.Debug("Updated http proxy diff: ", diff)
-		} else {
-			logger.Warnw("Error diffing http proxy", zap.Error(err))
-		}
-		logger.Debugf("Updated http proxy: %#v", update)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
 	}
 
 	// Before deleting old programming, check our cache to see whether there is anything to clean up.
@@ -258,9 +296,28 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) r
 			return err
 		}
 	}
+	if err := r.reconcileCertificateDelegations(ctx, ing); err != nil {
+		return err
+	}
 	ing.Status.MarkNetworkConfigured()
 
-	ready := ing.IsReady()
+	// Probing cannot succeed when Contour has rejected any of our proxies, so
+	// surface why instead.
+	ready, message := resources.AggregateProxyStatuses(actualProxies)
+	if message != "" {
+		ing.Status.MarkLoadBalancerFailed("HTTPProxyInvalid", message)
+		ing.Status.ObservedGeneration = ing.Generation
+		return nil
+	}
+	if !ready {
+		// Contour's status update of the proxies requeues us.
+		logger.Debug("Waiting for Contour to accept the http proxies.")
+		ing.Status.MarkLoadBalancerNotReady()
+		ing.Status.ObservedGeneration = ing.Generation
+		return nil
+	}
+
+	ready = ing.IsReady()
 
 	if ready {
 		// When the kingress has already been marked Ready for this generation,
@@ -310,6 +367,275 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, ing *v1alpha1.Ingress) r
 	return nil
 }
 
+// proxyWorkerLimit returns how many HTTPProxies may be created or updated at
+// once.
+func (r *Reconciler) proxyWorkerLimit() int {
+	if r.proxyWorkers < 1 {
+		return 1
+	}
+	return r.proxyWorkers
+}
+
+// reconcileProxy applies the desired HTTPProxy with server-side apply, under
+// the existing name if there is already one for the same parent, host and
+// class, and returns the result.
+func (r *Reconciler) reconcileProxy(ctx context.Context, proxy *v1.HTTPProxy) (*v1.HTTPProxy, error) {
+	logger := logging.FromContext(ctx)
+
+	selector := labels.Set(map[string]string{
+		resources.ParentKey:     proxy.Labels[resources.ParentKey],
+		resources.DomainHashKey: proxy.Labels[resources.DomainHashKey],
+		resources.ClassKey:      proxy.Labels[resources.ClassKey],
+	}).AsSelector()
+	matches, err := r.contourLister.HTTPProxies(proxy.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	name := proxy.Name
+	if len(matches) > 0 {
+		existing := matches[0]
+		if equality.Semantic.DeepEqual(existing.Annotations, proxy.Annotations) &&
+			equality.Semantic.DeepEqual(existing.Labels, proxy.Labels) &&
+			equality.Semantic.DeepEqual(existing.Spec, proxy.Spec) {
+			// Avoid applies that don't change anything.
+			return existing, nil
+		}
+		name = existing.Name
+		if err := r.upgradeProxyManagedFields(ctx, existing); err != nil {
+			return nil, err
+		}
+	}
+	patch, err := proxyApplyPatch(proxy, name)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := r.contourClient.ProjectcontourV1().HTTPProxies(proxy.Namespace).Patch(ctx, name,
+		types.ApplyPatchType, patch, metav1.PatchOptions{FieldManager: FieldManager, Force: ptr.Bool(true)})
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Applied http proxy: %s", patch)
+	return applied, nil
+}
+
+// proxyApplyPatch returns the server-side apply configuration of the desired
+// proxy under the given name. It carries only the fields net-contour owns, so
+// the status and server-populated metadata are left out.
+func proxyApplyPatch(proxy *v1.HTTPProxy, name string) ([]byte, error) {
+	apply := &v1.HTTPProxy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "HTTPProxy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       proxy.Namespace,
+			Labels:          proxy.Labels,
+			Annotations:     proxy.Annotations,
+			OwnerReferences: proxy.OwnerReferences,
+		},
+		Spec: proxy.Spec,
+	}
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(apply)
+	if err != nil {
+		return nil, err
+	}
+	unstructured.RemoveNestedField(u, "status")
+	unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+	return json.Marshal(u)
+}
+
+// legacyFieldManager is the field manager of the updates net-contour made
+// before it switched to server-side apply. The API server derives it from the
+// default user agent, as the clients do not set a field manager.
+var legacyFieldManager = strings.Split(rest.DefaultKubernetesUserAgent(), "/")[0]
+
+// upgradeProxyManagedFields moves the fields owned by legacyFieldManager's
+// updates of the proxy to FieldManager's apply entry. Without it, the fields
+// stay owned by the legacy manager and are never pruned when they are left
+// out of a later apply.
+func (r *Reconciler) upgradeProxyManagedFields(ctx context.Context, existing *v1.HTTPProxy) error {
+	patch, err := proxyManagedFieldsPatch(existing)
+	if err != nil || patch == nil {
+		return err
+	}
+	_, err = r.contourClient.ProjectcontourV1().HTTPProxies(existing.Namespace).Patch(ctx, existing.Name,
+		types.JSONPatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// proxyManagedFieldsPatch returns the JSON patch replacing the managed fields
+// of the proxy as described in upgradeProxyManagedFields, or nil when there is
+// nothing to upgrade. The patch fails if the proxy changed since it was read.
+func proxyManagedFieldsPatch(existing *v1.HTTPProxy) ([]byte, error) {
+	owned := &fieldpath.Set{}
+	var apply *metav1.ManagedFieldsEntry
+	managedFields := make([]metav1.ManagedFieldsEntry, 0, len(existing.ManagedFields))
+	for _, entry := range existing.ManagedFields {
+		switch {
+		case entry.Subresource != "" || entry.FieldsV1 == nil:
+		case entry.Manager == legacyFieldManager && entry.Operation == metav1.ManagedFieldsOperationUpdate:
+			fields := &fieldpath.Set{}
+			if err := fields.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+				return nil, fmt.Errorf("failed to decode the fields of manager %q: %w", entry.Manager, err)
+			}
+			owned = owned.Union(fields)
+			continue
+		case entry.Manager == FieldManager && entry.Operation == metav1.ManagedFieldsOperationApply:
+			entry := entry
+			apply = &entry
+			continue
+		}
+		managedFields = append(managedFields, entry)
+	}
+	if owned.Empty() {
+		return nil, nil
+	}
+
+	if apply == nil {
+		now := metav1.Now()
+		apply = &metav1.ManagedFieldsEntry{
+			Manager:    FieldManager,
+			Operation:  metav1.ManagedFieldsOperationApply,
+			APIVersion: v1.SchemeGroupVersion.String(),
+			FieldsType: "FieldsV1",
+			Time:       &now,
+		}
+	} else {
+		fields := &fieldpath.Set{}
+		if err := fields.FromJSON(bytes.NewReader(apply.FieldsV1.Raw)); err != nil {
+			return nil, fmt.Errorf("failed to decode the fields of manager %q: %w", apply.Manager, err)
+		}
+		owned = owned.Union(fields)
+	}
+	raw, err := owned.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	apply.FieldsV1 = &metav1.FieldsV1{Raw: raw}
+	managedFields = append(managedFields, *apply)
+
+	return json.Marshal([]map[string]interface{}{{
+		"op":    "test",
+		"path":  "/metadata/resourceVersion",
+		"value": existing.ResourceVersion,
+	}, {
+		"op":    "replace",
+		"path":  "/metadata/managedFields",
+		"value": managedFields,
+	}})
+}
+
+// reconcileCertificateDelegations checks that the TLS secrets the Ingress
+// references from other namespaces have been delegated to its namespace, as
+// Contour requires, creating the delegations when configured to.
+func (r *Reconciler) reconcileCertificateDelegations(ctx context.Context, ing *v1alpha1.Ingress) error {
+	logger := logging.FromContext(ctx)
+
+	used := sets.NewString()
+	missing := sets.NewString()
+	for _, tls := range ing.Spec.TLS {
+		if tls.SecretNamespace == "" || tls.SecretNamespace == ing.Namespace {
+			continue
+		}
+		used.Insert(tls.SecretNamespace + "/" + tls.SecretName)
+		// Re-check the Ingress when delegations are created by hand.
+		if err := r.tracker.TrackReference(tracker.Reference{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "TLSCertificateDelegation",
+			Namespace:  tls.SecretNamespace,
+			Selector:   &metav1.LabelSelector{},
+		}, ing); err != nil {
+			return err
+		}
+		delegations, err := r.delegationLister.TLSCertificateDelegations(tls.SecretNamespace).List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		// Those created for other Ingresses are deleted with them.
+		var usable []*v1.TLSCertificateDelegation
+		for _, delegation := range delegations {
+			if !resources.IsCreatedForOtherIngress(delegation, ing) {
+				usable = append(usable, delegation)
+			}
+		}
+		if resources.IsCertificateDelegated(usable, tls.SecretName, ing.Namespace) {
+			continue
+		}
+
+		if config.FromContext(ctx).Contour.AutoDelegateCertificates {
+			desired := resources.MakeCertificateDelegation(ing, tls.SecretNamespace, tls.SecretName)
+			delegation, err := r.contourClient.ProjectcontourV1().TLSCertificateDelegations(desired.Namespace).Create(ctx, desired, metav1.CreateOptions{})
+			if err == nil {
+				logger.Debugf("Created certificate delegation: %#v", delegation)
+				continue
+			} else if apierrs.IsAlreadyExists(err) {
+				continue
+			} else if !apierrs.IsForbidden(err) {
+				return err
+			}
+			logger.Warnw("Not permitted to create certificate delegation", zap.Error(err))
+		}
+		missing.Insert(tls.SecretNamespace + "/" + tls.SecretName)
+	}
+	if err := r.deleteUnusedCertificateDelegations(ctx, types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}, used); err != nil {
+		return err
+	}
+
+	if missing.Len() == 0 {
+		return ing.GetConditionSet().Manage(&ing.Status).ClearCondition(CertificateDelegatedCondition)
+	}
+	ing.GetConditionSet().Manage(&ing.Status).SetCondition(apis.Condition{
+		Type:     CertificateDelegatedCondition,
+		Status:   corev1.ConditionFalse,
+		Severity: apis.ConditionSeverityWarning,
+		Reason:   "CertificateNotDelegated",
+		Message: fmt.Sprintf("No TLSCertificateDelegation allows namespace %q to use TLS secrets %v.",
+			ing.Namespace, missing.List()),
+	})
+	return nil
+}
+
+// deleteUnusedCertificateDelegations deletes the TLSCertificateDelegations
+// created for the keyed Ingress whose secret is not among the used ones, given
+// as "<namespace>/<name>".
+func (r *Reconciler) deleteUnusedCertificateDelegations(ctx context.Context, key types.NamespacedName, used sets.String) error {
+	logger := logging.FromContext(ctx)
+
+	delegations, err := r.delegationLister.List(labels.SelectorFromSet(
+		resources.CertificateDelegationLabels(key.Name, key.Namespace)))
+	if err != nil {
+		return err
+	}
+	for _, delegation := range delegations {
+		unused := false
+		for _, d := range delegation.Spec.Delegations {
+			if !used.Has(delegation.Namespace + "/" + d.SecretName) {
+				unused = true
+				break
+			}
+		}
+		if !unused {
+			continue
+		}
+		err := r.contourClient.ProjectcontourV1().TLSCertificateDelegations(delegation.Namespace).Delete(ctx, delegation.Name, metav1.DeleteOptions{})
+		if err == nil {
+			logger.Debugf("Deleted unused certificate delegation %s/%s.", delegation.Namespace, delegation.Name)
+		} else if apierrs.IsForbidden(err) {
+			logger.Warnw("Not permitted to delete certificate delegation", zap.Error(err))
+		} else if !apierrs.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ObserveDeletion implements reconciler.OnDeletionInterface. It deletes the
+// certificate delegations created for the deleted Ingress.
+func (r *Reconciler) ObserveDeletion(ctx context.Context, key types.NamespacedName) error {
+	return r.deleteUnusedCertificateDelegations(ctx, key, sets.NewString())
+}
+
 func (r *Reconciler) lbStatus(ctx context.Context, vis v1alpha1.IngressVisibility) (lbs []v1alpha1.LoadBalancerIngressStatus) {
 	logger := logging.FromContext(ctx)
 
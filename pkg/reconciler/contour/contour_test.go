@@ -17,19 +17,27 @@ limitations under the License.
 package contour
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	v1 "github.com/projectcontour/contour/apis/projectcontour/v1"
 	"knative.dev/pkg/logging"
 
+	contourclientset "knative.dev/net-contour/pkg/client/clientset/versioned"
+	projectcontourv1 "knative.dev/net-contour/pkg/client/clientset/versioned/typed/projectcontour/v1"
 	fakecontourclient "knative.dev/net-contour/pkg/client/injection/client/fake"
 	fakeingressclient "knative.dev/networking/pkg/client/injection/client/fake"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
@@ -40,15 +48,18 @@ import (
 	clientgotesting "k8s.io/client-go/testing"
 	"knative.dev/net-contour/pkg/reconciler/contour/config"
 	"knative.dev/net-contour/pkg/reconciler/contour/resources"
+	"knative.dev/net-contour/pkg/reconciler/contour/resources/names"
 	"knative.dev/networking/pkg/apis/networking"
 	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 	ingressreconciler "knative.dev/networking/pkg/client/injection/reconciler/networking/v1alpha1/ingress"
 	netconfig "knative.dev/networking/pkg/config"
+	"knative.dev/pkg/apis"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
 	"knative.dev/pkg/kmeta"
 	"knative.dev/pkg/network"
 	"knative.dev/pkg/reconciler"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
 
 	. "knative.dev/net-contour/pkg/reconciler/testing"
 	. "knative.dev/pkg/reconciler/testing"
@@ -119,7 +130,7 @@ func TestReconcile(t *testing.T) {
 			ing("name", "ns", withBasicSpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour)),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withBasicSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -143,6 +154,106 @@ func TestReconcile(t *testing.T) {
 			},
 			Name: "name--ep",
 		}},
+	}, {
+		Name: "cross-namespace TLS secret not delegated",
+		Key:  "ns/name",
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), makeItReady),
+		}, servicesAndEndpoints...),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS)),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS, makeItReady, markCertificateNotDelegated),
+		}},
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "ns",
+				Resource:  v1alpha1.SchemeGroupVersion.WithResource("ingresses"),
+			},
+			Name: "name--ep",
+		}},
+	}, {
+		Name: "cross-namespace TLS secret delegated",
+		Key:  "ns/name",
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), makeItReady),
+			resources.MakeCertificateDelegation(ing("name", "ns"), "secrets", "cert"),
+		}, servicesAndEndpoints...),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS)),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS, makeItReady),
+		}},
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "ns",
+				Resource:  v1alpha1.SchemeGroupVersion.WithResource("ingresses"),
+			},
+			Name: "name--ep",
+		}},
+	}, {
+		Name:    "invalid annotation",
+		Key:     "ns/name",
+		WantErr: true,
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, withInvalidAnnotation),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withInvalidAnnotation), makeItReady),
+		}, servicesAndEndpoints...),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, withInvalidAnnotation, func(i *v1alpha1.Ingress) {
+				// These are the things we expect to change in status.
+				i.Status.InitializeConditions()
+				i.Status.MarkIngressNotReady(InvalidAnnotationReason, invalidAnnotationMessage)
+			}),
+		}},
+		WantEvents: []string{
+			Eventf(corev1.EventTypeWarning, "InternalError", invalidAnnotationMessage),
+		},
+	}, {
+		Name:    "invalid annotation rejected by endpoints probe",
+		Key:     "ns/name",
+		WantErr: true,
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, withInvalidAnnotation),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withInvalidAnnotation), func(i *v1alpha1.Ingress) {
+				i.Status.InitializeConditions()
+				i.Status.MarkIngressNotReady(InvalidAnnotationReason, invalidAnnotationMessage)
+			}),
+		}, servicesAndEndpoints...),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, withInvalidAnnotation, func(i *v1alpha1.Ingress) {
+				// These are the things we expect to change in status.
+				i.Status.InitializeConditions()
+				i.Status.MarkIngressNotReady(InvalidAnnotationReason, invalidAnnotationMessage)
+			}),
+		}},
+		WantEvents: []string{
+			Eventf(corev1.EventTypeWarning, "InternalError", invalidAnnotationMessage),
+		},
+	}, {
+		Name: "endpoints probe with fixed annotations",
+		Key:  "ns/name",
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withInvalidAnnotation), func(i *v1alpha1.Ingress) {
+				i.Status.InitializeConditions()
+				i.Status.MarkIngressNotReady(InvalidAnnotationReason, invalidAnnotationMessage)
+			}),
+		}, servicesAndEndpoints...),
+		WantUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), func(i *v1alpha1.Ingress) {
+				i.Status.InitializeConditions()
+				i.Status.MarkIngressNotReady(InvalidAnnotationReason, invalidAnnotationMessage)
+			}),
+		}},
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, func(i *v1alpha1.Ingress) {
+				// These are the things we expect to change in status.
+				i.Status.InitializeConditions()
+				i.Status.MarkLoadBalancerNotReady()
+				i.Status.MarkIngressNotReady("EndpointsNotReady", "Waiting for Envoys to receive Endpoints data.")
+			}),
+		}},
 	}, {
 		Name:    "failure deleting endpoints probe",
 		Key:     "ns/name",
@@ -154,7 +265,7 @@ func TestReconcile(t *testing.T) {
 			ing("name", "ns", withBasicSpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour)),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withBasicSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -240,7 +351,7 @@ func TestReconcile(t *testing.T) {
 		Objects: append(append([]runtime.Object{
 			ing("name", "ns", withBasicSpec, withContour, makeItReady),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), makeItReady),
-		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour))...), servicesAndEndpoints...),
+		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour), withValidStatus)...), servicesAndEndpoints...),
 		WantDeletes: []clientgotesting.DeleteActionImpl{{
 			ActionImpl: clientgotesting.ActionImpl{
 				Namespace: "ns",
@@ -251,21 +362,52 @@ func TestReconcile(t *testing.T) {
 	}, {
 		Name: "steady state basic ingress (no probe)",
 		Key:  "ns/name",
+		Objects: append(append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, makeItReady),
+		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour), withValidStatus)...), servicesAndEndpoints...),
+	}, {
+		Name: "steady state basic ingress (invalid http proxy)",
+		Key:  "ns/name",
+		Objects: append(append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, makeItReady),
+		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour), withInvalidStatus)...), servicesAndEndpoints...),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, makeItReady, func(i *v1alpha1.Ingress) {
+				i.Status.MarkLoadBalancerFailed("HTTPProxyInvalid",
+					`HTTPProxy "name--example.com" is invalid: Secret not found`)
+			}),
+		}},
+	}, {
+		Name: "steady state basic ingress (http proxy invalid for an earlier generation)",
+		Key:  "ns/name",
+		Objects: append(append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, makeItReady),
+		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour), withInvalidStatus, withGenerationBump)...), servicesAndEndpoints...),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			// Contour has not processed the current generation yet.
+			Object: ing("name", "ns", withBasicSpec, withContour, makeItReady, func(i *v1alpha1.Ingress) {
+				i.Status.MarkLoadBalancerNotReady()
+			}),
+		}},
+	}, {
+		Name: "steady state basic ingress (http proxy not processed yet)",
+		Key:  "ns/name",
 		Objects: append(append([]runtime.Object{
 			ing("name", "ns", withBasicSpec, withContour, makeItReady),
 		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour))...), servicesAndEndpoints...),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, makeItReady, func(i *v1alpha1.Ingress) {
+				i.Status.MarkLoadBalancerNotReady()
+			}),
+		}},
 	}, {
 		Name: "basic ingress changed",
 		Key:  "ns/name",
 		Objects: append(append([]runtime.Object{
 			ing("name", "ns", withContour, withGeneration(1), withBasicSpec2),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec2, withContour, withGeneration(1)), makeItReady),
-		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour))...), servicesAndEndpoints...),
-		WantUpdates: []clientgotesting.UpdateActionImpl{{
-			Object: mustMakeProxies(t,
-				ing("name", "ns", withContour, withGeneration(1), withBasicSpec2),
-			)[0],
-		}},
+		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour), withValidStatus)...), servicesAndEndpoints...),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withContour, withGeneration(1), withBasicSpec2)),
 		WantDeletes: []clientgotesting.DeleteActionImpl{{
 			ActionImpl: clientgotesting.ActionImpl{
 				Namespace: "ns",
@@ -300,6 +442,45 @@ func TestReconcile(t *testing.T) {
 				i.Status.ObservedGeneration = 1
 			}),
 		}},
+	}, {
+		Name: "basic ingress changed (http proxy written by updates)",
+		Key:  "ns/name",
+		Objects: append(append([]runtime.Object{
+			ing("name", "ns", withContour, withGeneration(1), withBasicSpec2),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec2, withContour, withGeneration(1)), makeItReady),
+		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour), withLegacyManagedFields, withValidStatus)...), servicesAndEndpoints...),
+		WantPatches: append(
+			mustUpgradeManagedFields(t, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour), withLegacyManagedFields)),
+			mustApplyProxies(t, ing("name", "ns", withContour, withGeneration(1), withBasicSpec2))...),
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "ns",
+				Resource:  v1alpha1.SchemeGroupVersion.WithResource("ingresses"),
+			},
+			Name: "name--ep",
+		}},
+		WantDeleteCollections: []clientgotesting.DeleteCollectionActionImpl{{
+			ListRestrictions: clientgotesting.ListRestrictions{
+				Labels: deleteSelector(t, 1),
+				Fields: fields.Everything(),
+			},
+		}},
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withContour, withGeneration(1), withBasicSpec2, func(i *v1alpha1.Ingress) {
+				i.Status.InitializeConditions()
+				i.Status.MarkNetworkConfigured()
+				i.Status.MarkLoadBalancerReady(
+					[]v1alpha1.LoadBalancerIngressStatus{{
+						DomainInternal: publicSvc,
+						IP:             publicSvcIP,
+					}},
+					[]v1alpha1.LoadBalancerIngressStatus{{
+						DomainInternal: privateSvc,
+						IP:             privateSvcIP,
+					}})
+				i.Status.ObservedGeneration = 1
+			}),
+		}},
 	}, {
 		Name: "first reconcile multi-httpproxy ingress",
 		Key:  "ns/name",
@@ -307,7 +488,7 @@ func TestReconcile(t *testing.T) {
 			ing("name", "ns", withMultiProxySpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withMultiProxySpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxies(t, ing("name", "ns", withMultiProxySpec, withContour)),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withMultiProxySpec, withContour)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withMultiProxySpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -336,13 +517,13 @@ func TestReconcile(t *testing.T) {
 		Key:     "ns/name",
 		WantErr: true,
 		WithReactors: []clientgotesting.ReactionFunc{
-			InduceFailure("create", "httpproxies"),
+			InduceFailure("patch", "httpproxies"),
 		},
 		Objects: append([]runtime.Object{
 			ing("name", "ns", withBasicSpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour)),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withBasicSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -350,24 +531,20 @@ func TestReconcile(t *testing.T) {
 			}),
 		}},
 		WantEvents: []string{
-			Eventf(corev1.EventTypeWarning, "InternalError", "inducing failure for create httpproxies"),
+			Eventf(corev1.EventTypeWarning, "InternalError", "inducing failure for patch httpproxies"),
 		},
 	}, {
 		Name:    "error updating http proxy",
 		Key:     "ns/name",
 		WantErr: true,
 		WithReactors: []clientgotesting.ReactionFunc{
-			InduceFailure("update", "httpproxies"),
+			InduceFailure("patch", "httpproxies"),
 		},
 		Objects: append(append([]runtime.Object{
 			ing("name", "ns", withContour, withGeneration(1), withBasicSpec2),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec2, withContour, withGeneration(1)), makeItReady),
 		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour))...), servicesAndEndpoints...),
-		WantUpdates: []clientgotesting.UpdateActionImpl{{
-			Object: mustMakeProxies(t,
-				ing("name", "ns", withContour, withGeneration(1), withBasicSpec2),
-			)[0],
-		}},
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withContour, withGeneration(1), withBasicSpec2)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withContour, withGeneration(1), withBasicSpec2, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -377,7 +554,7 @@ func TestReconcile(t *testing.T) {
 			}),
 		}},
 		WantEvents: []string{
-			Eventf(corev1.EventTypeWarning, "InternalError", "inducing failure for update httpproxies"),
+			Eventf(corev1.EventTypeWarning, "InternalError", "inducing failure for patch httpproxies"),
 		},
 	}, {
 		Name:    "error deleting collection",
@@ -390,11 +567,7 @@ func TestReconcile(t *testing.T) {
 			ing("name", "ns", withContour, withGeneration(1), withBasicSpec2),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec2, withContour, withGeneration(1)), makeItReady),
 		}, mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour))...), servicesAndEndpoints...),
-		WantUpdates: []clientgotesting.UpdateActionImpl{{
-			Object: mustMakeProxies(t,
-				ing("name", "ns", withContour, withGeneration(1), withBasicSpec2),
-			)[0],
-		}},
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withContour, withGeneration(1), withBasicSpec2)),
 		WantDeleteCollections: []clientgotesting.DeleteCollectionActionImpl{{
 			ListRestrictions: clientgotesting.ListRestrictions{
 				// We delete the things that don't match the generation being reconciled.
@@ -424,7 +597,7 @@ func TestReconcile(t *testing.T) {
 			ing("name", "ns", withBasicSpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour)),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withBasicSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -471,12 +644,13 @@ func TestReconcile(t *testing.T) {
 
 	table.Test(t, MakeFactory(func(ctx context.Context, listers *Listers, cmw configmap.Watcher) controller.Reconciler {
 		r := &Reconciler{
-			ingressClient: fakeingressclient.Get(ctx),
-			contourClient: fakecontourclient.Get(ctx),
-			ingressLister: listers.GetIngressLister(),
-			contourLister: listers.GetHTTPProxyLister(),
-			serviceLister: listers.GetK8sServiceLister(),
-			tracker:       &NullTracker{},
+			ingressClient:    fakeingressclient.Get(ctx),
+			contourClient:    fakecontourclient.Get(ctx),
+			ingressLister:    listers.GetIngressLister(),
+			contourLister:    listers.GetHTTPProxyLister(),
+			delegationLister: listers.GetTLSCertificateDelegationLister(),
+			serviceLister:    listers.GetK8sServiceLister(),
+			tracker:          &NullTracker{},
 			statusManager: &fakeStatusManager{
 				FakeIsReady: func(context.Context, *v1alpha1.Ingress) (bool, error) {
 					return true, nil
@@ -495,6 +669,469 @@ func TestReconcile(t *testing.T) {
 	}))
 }
 
+func TestReconcileAutoDelegateCertificates(t *testing.T) {
+	table := TableTest{{
+		Name: "creates missing delegation",
+		Key:  "ns/name",
+		// The delegation is created in the secret's namespace.
+		SkipNamespaceValidation: true,
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), makeItReady),
+		}, servicesAndEndpoints...),
+		WantCreates: []runtime.Object{resources.MakeCertificateDelegation(ing("name", "ns"), "secrets", "cert")},
+		WantPatches: mustApplyProxiesWithConfig(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), autoDelegateConfig),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS, makeItReady),
+		}},
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "ns",
+				Resource:  v1alpha1.SchemeGroupVersion.WithResource("ingresses"),
+			},
+			Name: "name--ep",
+		}},
+	}, {
+		Name: "not permitted to create delegation",
+		Key:  "ns/name",
+		// The delegation is created in the secret's namespace.
+		SkipNamespaceValidation: true,
+		WithReactors: []clientgotesting.ReactionFunc{
+			func(action clientgotesting.Action) (bool, runtime.Object, error) {
+				if !action.Matches("create", "tlscertificatedelegations") {
+					return false, nil, nil
+				}
+				return true, nil, apierrs.NewForbidden(v1.SchemeGroupVersion.WithResource("tlscertificatedelegations").GroupResource(),
+					names.CertificateDelegation(ing("name", "ns"), "cert"), errors.New("not allowed"))
+			},
+		},
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), makeItReady),
+		}, servicesAndEndpoints...),
+		WantCreates: []runtime.Object{resources.MakeCertificateDelegation(ing("name", "ns"), "secrets", "cert")},
+		WantPatches: mustApplyProxiesWithConfig(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), autoDelegateConfig),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS, makeItReady, markCertificateNotDelegated),
+		}},
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "ns",
+				Resource:  v1alpha1.SchemeGroupVersion.WithResource("ingresses"),
+			},
+			Name: "name--ep",
+		}},
+	}, {
+		Name: "deletes delegation no longer used",
+		Key:  "ns/name",
+		// The delegation lives in the secret's namespace.
+		SkipNamespaceValidation: true,
+		Objects: append(append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, makeItReady),
+			resources.MakeCertificateDelegation(ing("name", "ns"), "secrets", "cert"),
+		}, mustMakeProxiesWithConfig(t, ing("name", "ns", withBasicSpec, withContour), autoDelegateConfig, withValidStatus)...), servicesAndEndpoints...),
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "secrets",
+				Resource:  v1.SchemeGroupVersion.WithResource("tlscertificatedelegations"),
+			},
+			Name: names.CertificateDelegation(ing("name", "ns"), "cert"),
+		}},
+	}, {
+		Name: "keeps delegation created for another ingress",
+		Key:  "ns/name",
+		Objects: append(append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, makeItReady),
+			ing("other", "ns", withBasicSpec, withContour, withCrossNamespaceTLS),
+			resources.MakeCertificateDelegation(ing("other", "ns"), "secrets", "cert"),
+		}, mustMakeProxiesWithConfig(t, ing("name", "ns", withBasicSpec, withContour), autoDelegateConfig, withValidStatus)...), servicesAndEndpoints...),
+	}, {
+		Name: "creates delegation beside one created for another ingress",
+		Key:  "ns/name",
+		// The delegation is created in the secret's namespace.
+		SkipNamespaceValidation: true,
+		Objects: append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS),
+			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), makeItReady),
+			// It is deleted once the other Ingress stops using it.
+			resources.MakeCertificateDelegation(ing("other", "ns"), "secrets", "cert"),
+		}, servicesAndEndpoints...),
+		WantCreates: []runtime.Object{resources.MakeCertificateDelegation(ing("name", "ns"), "secrets", "cert")},
+		WantPatches: mustApplyProxiesWithConfig(t, ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS), autoDelegateConfig),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: ing("name", "ns", withBasicSpec, withContour, withCrossNamespaceTLS, makeItReady),
+		}},
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "ns",
+				Resource:  v1alpha1.SchemeGroupVersion.WithResource("ingresses"),
+			},
+			Name: "name--ep",
+		}},
+	}, {
+		Name: "keeps delegation it did not create",
+		Key:  "ns/name",
+		Objects: append(append([]runtime.Object{
+			ing("name", "ns", withBasicSpec, withContour, makeItReady),
+			withoutLabels(resources.MakeCertificateDelegation(ing("name", "ns"), "secrets", "cert")),
+		}, mustMakeProxiesWithConfig(t, ing("name", "ns", withBasicSpec, withContour), autoDelegateConfig, withValidStatus)...), servicesAndEndpoints...),
+	}, {
+		Name: "deletes delegation after the ingress is deleted",
+		Key:  "ns/name",
+		// The delegation lives in the secret's namespace.
+		SkipNamespaceValidation: true,
+		Objects: []runtime.Object{
+			resources.MakeCertificateDelegation(ing("name", "ns"), "secrets", "cert"),
+			resources.MakeCertificateDelegation(ing("other", "ns"), "secrets", "cert"),
+			resources.MakeCertificateDelegation(ing("name", "other-ns"), "secrets", "cert"),
+		},
+		WantDeletes: []clientgotesting.DeleteActionImpl{{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: "secrets",
+				Resource:  v1.SchemeGroupVersion.WithResource("tlscertificatedelegations"),
+			},
+			Name: names.CertificateDelegation(ing("name", "ns"), "cert"),
+		}},
+	}}
+
+	table.Test(t, MakeFactory(func(ctx context.Context, listers *Listers, cmw configmap.Watcher) controller.Reconciler {
+		r := &Reconciler{
+			ingressClient:    fakeingressclient.Get(ctx),
+			contourClient:    fakecontourclient.Get(ctx),
+			ingressLister:    listers.GetIngressLister(),
+			contourLister:    listers.GetHTTPProxyLister(),
+			delegationLister: listers.GetTLSCertificateDelegationLister(),
+			serviceLister:    listers.GetK8sServiceLister(),
+			tracker:          &NullTracker{},
+			statusManager: &fakeStatusManager{
+				FakeIsReady: func(context.Context, *v1alpha1.Ingress) (bool, error) {
+					return true, nil
+				},
+			},
+		}
+
+		ingr := ingressreconciler.NewReconciler(ctx, logging.FromContext(ctx), fakeingressclient.Get(ctx),
+			listers.GetIngressLister(), controller.GetEventRecorder(ctx), r, ContourIngressClassName,
+			controller.Options{
+				ConfigStore: &testConfigStore{
+					config: autoDelegateConfig,
+				}})
+
+		return ingr
+	}))
+}
+
+func TestReconcileConcurrentProxies(t *testing.T) {
+	// More hosts, and so proxies, than workers.
+	withManyHosts := func(i *v1alpha1.Ingress) {
+		i.Spec.Rules[0].Hosts = []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com", "g.com", "h.com"}
+	}
+
+	tests := []struct {
+		name    string
+		workers int
+		reactor clientgotesting.ReactionFunc
+		wantErr bool
+		// maxPatches bounds how many patches are attempted, when not all of
+		// them are.
+		maxPatches int
+	}{{
+		name:    "applies all proxies",
+		workers: 3,
+	}, {
+		name:    "applies all proxies, one at a time",
+		workers: 1,
+	}, {
+		name:    "failure applying a proxy",
+		workers: 1,
+		reactor: InduceFailure("patch", "httpproxies"),
+		wantErr: true,
+		// The failure cancels the proxies still waiting for a worker.
+		maxPatches: 1,
+	}, {
+		name:       "failure applying proxies concurrently",
+		workers:    3,
+		reactor:    InduceFailure("patch", "httpproxies"),
+		wantErr:    true,
+		maxPatches: 3,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := SetupFakeContext(t)
+			ctx = (&testConfigStore{config: defaultConfig}).ToContext(ctx)
+			contourClient := fakecontourclient.Get(ctx)
+			PrependHTTPProxyApplyReactor(contourClient)
+			if test.reactor != nil {
+				contourClient.PrependReactor("*", "*", test.reactor)
+			}
+			// The fake clientset runs its reactors under a lock, so the patches
+			// in flight are counted around it.
+			counter := &inFlightProxyPatches{ProjectcontourV1Interface: contourClient.ProjectcontourV1()}
+
+			listers := NewListers(append([]runtime.Object{
+				mustMakeProbe(t, ing("name", "ns", withMultiProxySpec, withManyHosts, withContour), makeItReady),
+			}, servicesAndEndpoints...))
+			r := &Reconciler{
+				ingressClient:    fakeingressclient.Get(ctx),
+				contourClient:    &countingContourClient{Interface: contourClient, v1: counter},
+				ingressLister:    listers.GetIngressLister(),
+				contourLister:    listers.GetHTTPProxyLister(),
+				delegationLister: listers.GetTLSCertificateDelegationLister(),
+				serviceLister:    listers.GetK8sServiceLister(),
+				tracker:          &NullTracker{},
+				statusManager: &fakeStatusManager{
+					FakeIsReady: func(context.Context, *v1alpha1.Ingress) (bool, error) {
+						return false, nil
+					},
+				},
+				proxyWorkers: test.workers,
+			}
+
+			i := ing("name", "ns", withMultiProxySpec, withManyHosts, withContour)
+			wantProxies := mustMakeProxies(t, i)
+			if len(wantProxies) <= test.workers {
+				t.Fatalf("Got %d proxies, wanted more than the %d workers", len(wantProxies), test.workers)
+			}
+			err := r.ReconcileKind(ctx, i)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ReconcileKind() = %v, wantErr = %v", err, test.wantErr)
+			}
+			if peak := counter.peak(); peak > test.workers {
+				t.Errorf("Got %d concurrent patches, wanted at most %d", peak, test.workers)
+			}
+			if test.wantErr {
+				if got := counter.total(); got > test.maxPatches {
+					t.Errorf("Got %d patches, wanted at most %d", got, test.maxPatches)
+				}
+				if want := "inducing failure for patch httpproxies"; err.Error() != want {
+					t.Errorf("ReconcileKind() = %v, wanted %s", err, want)
+				}
+				return
+			}
+			if got := counter.total(); got != len(wantProxies) {
+				t.Errorf("Got %d patches, wanted %d", got, len(wantProxies))
+			}
+
+			list, err := contourClient.ProjectcontourV1().HTTPProxies("ns").List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatal("List() =", err)
+			}
+			got := make(map[string]v1.HTTPProxySpec, len(list.Items))
+			for _, proxy := range list.Items {
+				got[proxy.Name] = proxy.Spec
+			}
+			want := make(map[string]v1.HTTPProxySpec)
+			for _, obj := range wantProxies {
+				proxy := obj.(*v1.HTTPProxy)
+				want[proxy.Name] = proxy.Spec
+			}
+			// The applied proxies went through JSON, which drops empty slices.
+			if !cmp.Equal(want, got, cmpopts.EquateEmpty()) {
+				t.Error("HTTPProxies (-want, +got):", cmp.Diff(want, got, cmpopts.EquateEmpty()))
+			}
+		})
+	}
+}
+
+// countingContourClient hands out inFlightProxyPatches as its v1 client.
+type countingContourClient struct {
+	contourclientset.Interface
+	v1 *inFlightProxyPatches
+}
+
+func (c *countingContourClient) ProjectcontourV1() projectcontourv1.ProjectcontourV1Interface {
+	return c.v1
+}
+
+// inFlightProxyPatches counts the HTTPProxy patches made through it, and the
+// most of them in flight at once. Each patch is held for a little while, so
+// that concurrent ones overlap.
+type inFlightProxyPatches struct {
+	projectcontourv1.ProjectcontourV1Interface
+
+	mu                      sync.Mutex
+	inFlight, most, patches int
+}
+
+func (c *inFlightProxyPatches) HTTPProxies(namespace string) projectcontourv1.HTTPProxyInterface {
+	return &inFlightProxyPatchesFor{HTTPProxyInterface: c.ProjectcontourV1Interface.HTTPProxies(namespace), counter: c}
+}
+
+func (c *inFlightProxyPatches) peak() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.most
+}
+
+func (c *inFlightProxyPatches) total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.patches
+}
+
+type inFlightProxyPatchesFor struct {
+	projectcontourv1.HTTPProxyInterface
+	counter *inFlightProxyPatches
+}
+
+func (c *inFlightProxyPatchesFor) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*v1.HTTPProxy, error) {
+	c.counter.mu.Lock()
+	c.counter.patches++
+	c.counter.inFlight++
+	if c.counter.inFlight > c.counter.most {
+		c.counter.most = c.counter.inFlight
+	}
+	c.counter.mu.Unlock()
+	defer func() {
+		c.counter.mu.Lock()
+		c.counter.inFlight--
+		c.counter.mu.Unlock()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	return c.HTTPProxyInterface.Patch(ctx, name, pt, data, opts, subresources...)
+}
+
+func TestProxyManagedFieldsPatch(t *testing.T) {
+	const (
+		staleLabel = `{"f:metadata":{"f:labels":{"f:stale":{}}}}`
+		spec       = `{"f:spec":{"f:virtualhost":{"f:fqdn":{}}}}`
+		both       = `{"f:metadata":{"f:labels":{"f:stale":{}}},"f:spec":{"f:virtualhost":{"f:fqdn":{}}}}`
+		annotation = `{"f:metadata":{"f:annotations":{"f:note":{}}}}`
+		status     = `{"f:status":{"f:currentStatus":{}}}`
+	)
+	entry := func(manager string, op metav1.ManagedFieldsOperationType, subresource, fields string) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{
+			Manager:     manager,
+			Operation:   op,
+			APIVersion:  v1.SchemeGroupVersion.String(),
+			FieldsType:  "FieldsV1",
+			FieldsV1:    &metav1.FieldsV1{Raw: []byte(fields)},
+			Subresource: subresource,
+		}
+	}
+	update, apply := metav1.ManagedFieldsOperationUpdate, metav1.ManagedFieldsOperationApply
+
+	tests := []struct {
+		name          string
+		managedFields []metav1.ManagedFieldsEntry
+		// want maps the managers of the upgraded entries to their fields,
+		// or is nil when nothing needs upgrading.
+		want map[string]string
+	}{{
+		name: "no managed fields",
+	}, {
+		name: "already applied",
+		managedFields: []metav1.ManagedFieldsEntry{
+			entry(FieldManager, apply, "", spec),
+			entry("contour", update, "status", status),
+		},
+	}, {
+		name: "other updates",
+		managedFields: []metav1.ManagedFieldsEntry{
+			entry(FieldManager, apply, "", spec),
+			entry("kubectl-edit", update, "", annotation),
+		},
+	}, {
+		name: "written by updates",
+		managedFields: []metav1.ManagedFieldsEntry{
+			entry(legacyFieldManager, update, "", both),
+			entry("contour", update, "status", status),
+		},
+		want: map[string]string{
+			FieldManager: both,
+			"contour":    status,
+		},
+	}, {
+		name: "written by updates, then applied",
+		managedFields: []metav1.ManagedFieldsEntry{
+			entry(legacyFieldManager, update, "", staleLabel),
+			entry("kubectl-edit", update, "", annotation),
+			entry(FieldManager, apply, "", spec),
+			entry("contour", update, "status", status),
+		},
+		want: map[string]string{
+			FieldManager:   both,
+			"kubectl-edit": annotation,
+			"contour":      status,
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy := &v1.HTTPProxy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "name",
+					Namespace:       "ns",
+					ResourceVersion: "42",
+					ManagedFields:   test.managedFields,
+				},
+			}
+			patch, err := proxyManagedFieldsPatch(proxy)
+			if err != nil {
+				t.Fatal("proxyManagedFieldsPatch() =", err)
+			}
+			if test.want == nil {
+				if patch != nil {
+					t.Errorf("proxyManagedFieldsPatch() = %s, wanted nil", patch)
+				}
+				return
+			}
+
+			var ops []struct {
+				Op    string          `json:"op"`
+				Path  string          `json:"path"`
+				Value json.RawMessage `json:"value"`
+			}
+			if err := json.Unmarshal(patch, &ops); err != nil {
+				t.Fatal("json.Unmarshal() =", err)
+			}
+			if len(ops) != 2 || ops[0].Op != "test" || ops[0].Path != "/metadata/resourceVersion" || string(ops[0].Value) != `"42"` ||
+				ops[1].Op != "replace" || ops[1].Path != "/metadata/managedFields" {
+				t.Fatalf("proxyManagedFieldsPatch() = %s, wanted a resourceVersion test and a managedFields replace", patch)
+			}
+			var managedFields []metav1.ManagedFieldsEntry
+			if err := json.Unmarshal(ops[1].Value, &managedFields); err != nil {
+				t.Fatal("json.Unmarshal() =", err)
+			}
+
+			got := make(map[string]*fieldpath.Set, len(managedFields))
+			for _, entry := range managedFields {
+				if entry.Manager == FieldManager && entry.Operation != apply {
+					t.Errorf("%s entry has operation %s, wanted %s", FieldManager, entry.Operation, apply)
+				}
+				got[entry.Manager] = mustParseFields(t, entry.FieldsV1.Raw)
+			}
+			want := make(map[string]*fieldpath.Set, len(test.want))
+			for manager, fields := range test.want {
+				want[manager] = mustParseFields(t, []byte(fields))
+			}
+			if !cmp.Equal(want, got, cmp.Comparer(func(a, b *fieldpath.Set) bool { return a.Equals(b) })) {
+				t.Errorf("managed fields = %v, wanted %v", got, want)
+			}
+
+			// Only owned by net-contour, the stale label is removed by the
+			// next apply, which leaves it out.
+			label := fieldpath.MakePathOrDie("metadata", "labels", "stale")
+			for manager, fields := range got {
+				if owns := fields.Has(label); owns != (manager == FieldManager) {
+					t.Errorf("%s owns the stale label: %v", manager, owns)
+				}
+			}
+		})
+	}
+}
+
+func mustParseFields(t *testing.T, raw []byte) *fieldpath.Set {
+	t.Helper()
+	fields := &fieldpath.Set{}
+	if err := fields.FromJSON(bytes.NewReader(raw)); err != nil {
+		t.Fatal("FromJSON() =", err)
+	}
+	return fields
+}
+
 func TestReconcileInternalEncryption(t *testing.T) {
 	table := TableTest{{
 		Name: "first reconcile basic ingress",
@@ -518,7 +1155,7 @@ func TestReconcileInternalEncryption(t *testing.T) {
 			ing("name", "ns", withTLSServiceSpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withTLSServiceSpec, withContour), makeItReady),
 		}, tlsServiceAndEndpoint...),
-		WantCreates: mustMakeProxiesWithConfig(t, ing("name", "ns", withTLSServiceSpec, withContour), internalEncryptionConfig),
+		WantPatches: mustApplyProxiesWithConfig(t, ing("name", "ns", withTLSServiceSpec, withContour), internalEncryptionConfig),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withTLSServiceSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -549,7 +1186,7 @@ func TestReconcileInternalEncryption(t *testing.T) {
 			ing("dm-name", "ns", withDomainMappingSpec, withContour),
 			mustMakeProbe(t, ing("dm-name", "ns", withDomainMappingSpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxiesWithConfig(t, ing("dm-name", "ns", withDomainMappingSpec, withContour), internalEncryptionConfig),
+		WantPatches: mustApplyProxiesWithConfig(t, ing("dm-name", "ns", withDomainMappingSpec, withContour), internalEncryptionConfig),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("dm-name", "ns", withDomainMappingSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -577,12 +1214,13 @@ func TestReconcileInternalEncryption(t *testing.T) {
 
 	table.Test(t, MakeFactory(func(ctx context.Context, listers *Listers, cmw configmap.Watcher) controller.Reconciler {
 		r := &Reconciler{
-			ingressClient: fakeingressclient.Get(ctx),
-			contourClient: fakecontourclient.Get(ctx),
-			ingressLister: listers.GetIngressLister(),
-			contourLister: listers.GetHTTPProxyLister(),
-			serviceLister: listers.GetK8sServiceLister(),
-			tracker:       &NullTracker{},
+			ingressClient:    fakeingressclient.Get(ctx),
+			contourClient:    fakecontourclient.Get(ctx),
+			ingressLister:    listers.GetIngressLister(),
+			contourLister:    listers.GetHTTPProxyLister(),
+			delegationLister: listers.GetTLSCertificateDelegationLister(),
+			serviceLister:    listers.GetK8sServiceLister(),
+			tracker:          &NullTracker{},
 			statusManager: &fakeStatusManager{
 				FakeIsReady: func(context.Context, *v1alpha1.Ingress) (bool, error) {
 					return true, nil
@@ -606,7 +1244,7 @@ func TestReconcileProberNotReady(t *testing.T) {
 			ing("name", "ns", withBasicSpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour)),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withBasicSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -619,12 +1257,13 @@ func TestReconcileProberNotReady(t *testing.T) {
 
 	table.Test(t, MakeFactory(func(ctx context.Context, listers *Listers, cmw configmap.Watcher) controller.Reconciler {
 		r := &Reconciler{
-			ingressClient: fakeingressclient.Get(ctx),
-			contourClient: fakecontourclient.Get(ctx),
-			ingressLister: listers.GetIngressLister(),
-			contourLister: listers.GetHTTPProxyLister(),
-			serviceLister: listers.GetK8sServiceLister(),
-			tracker:       &NullTracker{},
+			ingressClient:    fakeingressclient.Get(ctx),
+			contourClient:    fakecontourclient.Get(ctx),
+			ingressLister:    listers.GetIngressLister(),
+			contourLister:    listers.GetHTTPProxyLister(),
+			delegationLister: listers.GetTLSCertificateDelegationLister(),
+			serviceLister:    listers.GetK8sServiceLister(),
+			tracker:          &NullTracker{},
 			statusManager: &fakeStatusManager{
 				FakeIsReady: func(context.Context, *v1alpha1.Ingress) (bool, error) {
 					return false, nil
@@ -651,7 +1290,7 @@ func TestReconcileProbeError(t *testing.T) {
 			ing("name", "ns", withBasicSpec, withContour),
 			mustMakeProbe(t, ing("name", "ns", withBasicSpec, withContour), makeItReady),
 		}, servicesAndEndpoints...),
-		WantCreates: mustMakeProxies(t, ing("name", "ns", withBasicSpec, withContour)),
+		WantPatches: mustApplyProxies(t, ing("name", "ns", withBasicSpec, withContour)),
 		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 			Object: ing("name", "ns", withBasicSpec, withContour, func(i *v1alpha1.Ingress) {
 				// These are the things we expect to change in status.
@@ -666,12 +1305,13 @@ func TestReconcileProbeError(t *testing.T) {
 
 	table.Test(t, MakeFactory(func(ctx context.Context, listers *Listers, cmw configmap.Watcher) controller.Reconciler {
 		r := &Reconciler{
-			ingressClient: fakeingressclient.Get(ctx),
-			contourClient: fakecontourclient.Get(ctx),
-			ingressLister: listers.GetIngressLister(),
-			contourLister: listers.GetHTTPProxyLister(),
-			serviceLister: listers.GetK8sServiceLister(),
-			tracker:       &NullTracker{},
+			ingressClient:    fakeingressclient.Get(ctx),
+			contourClient:    fakecontourclient.Get(ctx),
+			ingressLister:    listers.GetIngressLister(),
+			contourLister:    listers.GetHTTPProxyLister(),
+			delegationLister: listers.GetTLSCertificateDelegationLister(),
+			serviceLister:    listers.GetK8sServiceLister(),
+			tracker:          &NullTracker{},
 			statusManager: &fakeStatusManager{
 				FakeIsReady: func(context.Context, *v1alpha1.Ingress) (bool, error) {
 					return false, theError
@@ -713,6 +1353,15 @@ var (
 			},
 		},
 	}
+	autoDelegateConfig = &config.Config{
+		Contour: &config.Contour{
+			VisibilityKeys: map[v1alpha1.IngressVisibility]sets.String{
+				v1alpha1.IngressVisibilityClusterLocal: sets.NewString(privateKey),
+				v1alpha1.IngressVisibilityExternalIP:   sets.NewString(publicKey),
+			},
+			AutoDelegateCertificates: true,
+		},
+	}
 	internalEncryptionConfig = &config.Config{
 		Contour: &config.Contour{
 			VisibilityKeys: map[v1alpha1.IngressVisibility]sets.String{
@@ -860,6 +1509,62 @@ var (
 
 type HTTPProxyOption func(*v1.HTTPProxy)
 
+// withoutLabels removes the labels of the delegation, as on one created by
+// hand.
+func withoutLabels(d *v1.TLSCertificateDelegation) *v1.TLSCertificateDelegation {
+	d.Labels = nil
+	return d
+}
+
+// withValidStatus marks the HTTPProxy as accepted by Contour.
+func withValidStatus(p *v1.HTTPProxy) {
+	p.Status.Conditions = append(p.Status.Conditions, v1.DetailedCondition{
+		Condition: v1.Condition{
+			Type:               v1.ValidConditionType,
+			Status:             v1.ConditionTrue,
+			ObservedGeneration: p.Generation,
+		},
+	})
+}
+
+func withInvalidStatus(p *v1.HTTPProxy) {
+	cond := v1.DetailedCondition{
+		Condition: v1.Condition{
+			Type:   v1.ValidConditionType,
+			Status: v1.ConditionTrue,
+		},
+	}
+	cond.AddError(v1.ConditionTypeTLSError, "SecretNotValid", "Secret not found")
+	p.Status.Conditions = append(p.Status.Conditions, cond)
+}
+
+// withGenerationBump advances the generation of the HTTPProxy past the one
+// its status was recorded for.
+func withGenerationBump(p *v1.HTTPProxy) {
+	p.Generation++
+}
+
+// withLegacyManagedFields records the HTTPProxy as written by the updates
+// net-contour made before it used server-side apply, including a label it no
+// longer sets.
+func withLegacyManagedFields(p *v1.HTTPProxy) {
+	p.ResourceVersion = "1"
+	p.Labels["stale"] = "label"
+	p.ManagedFields = []metav1.ManagedFieldsEntry{{
+		Manager:    legacyFieldManager,
+		Operation:  metav1.ManagedFieldsOperationUpdate,
+		APIVersion: v1.SchemeGroupVersion.String(),
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:stale":{}}},"f:spec":{}}`)},
+	}, {
+		Manager:    FieldManager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: v1.SchemeGroupVersion.String(),
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:virtualhost":{}}}`)},
+	}}
+}
+
 func mustMakeProxies(t *testing.T, i *v1alpha1.Ingress, opts ...HTTPProxyOption) (objs []runtime.Object) {
 	return mustMakeProxiesWithConfig(t, i, defaultConfig, opts...)
 }
@@ -867,7 +1572,10 @@ func mustMakeProxies(t *testing.T, i *v1alpha1.Ingress, opts ...HTTPProxyOption)
 func mustMakeProxiesWithConfig(t *testing.T, i *v1alpha1.Ingress, cfg *config.Config, opts ...HTTPProxyOption) (objs []runtime.Object) {
 	t.Helper()
 	ctx := (&testConfigStore{config: cfg}).ToContext(context.Background())
-	ps := resources.MakeHTTPProxies(ctx, i, serviceToProtocol)
+	ps, err := resources.MakeHTTPProxies(ctx, i, serviceToProtocol)
+	if err != nil {
+		t.Fatal("MakeHTTPProxies() =", err)
+	}
 	for _, p := range ps {
 		for _, opt := range opts {
 			opt(p)
@@ -877,6 +1585,50 @@ func mustMakeProxiesWithConfig(t *testing.T, i *v1alpha1.Ingress, cfg *config.Co
 	return
 }
 
+func mustApplyProxies(t *testing.T, i *v1alpha1.Ingress, opts ...HTTPProxyOption) []clientgotesting.PatchActionImpl {
+	return mustApplyProxiesWithConfig(t, i, defaultConfig, opts...)
+}
+
+func mustApplyProxiesWithConfig(t *testing.T, i *v1alpha1.Ingress, cfg *config.Config, opts ...HTTPProxyOption) (patches []clientgotesting.PatchActionImpl) {
+	t.Helper()
+	for _, obj := range mustMakeProxiesWithConfig(t, i, cfg, opts...) {
+		proxy := obj.(*v1.HTTPProxy)
+		patch, err := proxyApplyPatch(proxy, proxy.Name)
+		if err != nil {
+			t.Fatal("proxyApplyPatch() =", err)
+		}
+		patches = append(patches, clientgotesting.PatchActionImpl{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: proxy.Namespace,
+			},
+			Name:      proxy.Name,
+			PatchType: types.ApplyPatchType,
+			Patch:     patch,
+		})
+	}
+	return
+}
+
+func mustUpgradeManagedFields(t *testing.T, objs []runtime.Object) (patches []clientgotesting.PatchActionImpl) {
+	t.Helper()
+	for _, obj := range objs {
+		proxy := obj.(*v1.HTTPProxy)
+		patch, err := proxyManagedFieldsPatch(proxy)
+		if err != nil {
+			t.Fatal("proxyManagedFieldsPatch() =", err)
+		}
+		patches = append(patches, clientgotesting.PatchActionImpl{
+			ActionImpl: clientgotesting.ActionImpl{
+				Namespace: proxy.Namespace,
+			},
+			Name:      proxy.Name,
+			PatchType: types.JSONPatchType,
+			Patch:     patch,
+		})
+	}
+	return
+}
+
 func deleteSelector(t *testing.T, generation int) labels.Selector {
 	l, err := labels.Parse(fmt.Sprintf("%s=name,%s!=%d",
 		resources.ParentKey, resources.GenerationKey, generation))
@@ -1045,12 +1797,39 @@ func withDomainMappingSpec(i *v1alpha1.Ingress) {
 	}
 }
 
+func withCrossNamespaceTLS(i *v1alpha1.Ingress) {
+	i.Spec.TLS = []v1alpha1.IngressTLS{{
+		Hosts:           []string{"example.com"},
+		SecretName:      "cert",
+		SecretNamespace: "secrets",
+	}}
+}
+
+func markCertificateNotDelegated(i *v1alpha1.Ingress) {
+	i.GetConditionSet().Manage(&i.Status).SetCondition(apis.Condition{
+		Type:     CertificateDelegatedCondition,
+		Status:   corev1.ConditionFalse,
+		Severity: apis.ConditionSeverityWarning,
+		Reason:   "CertificateNotDelegated",
+		Message:  `No TLSCertificateDelegation allows namespace "ns" to use TLS secrets [secrets/cert].`,
+	})
+}
+
 func withAnnotation(ann map[string]string) IngressOption {
 	return func(i *v1alpha1.Ingress) {
 		i.Annotations = kmeta.UnionMaps(i.Annotations, ann)
 	}
 }
 
+const invalidAnnotationMessage = `failed to parse "contour.networking.knative.dev/auth-with-request-body": strconv.ParseBool: parsing "nope": invalid syntax`
+
+func withInvalidAnnotation(i *v1alpha1.Ingress) {
+	withAnnotation(map[string]string{
+		resources.ExtensionServiceKey:    "es",
+		resources.AuthWithRequestBodyKey: "nope",
+	})(i)
+}
+
 func withGeneration(gen int64) IngressOption {
 	return func(i *v1alpha1.Ingress) {
 		i.Generation = gen
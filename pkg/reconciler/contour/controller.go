@@ -19,8 +19,10 @@ package contour
 import (
 	"context"
 
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
 	contourclient "knative.dev/net-contour/pkg/client/injection/client"
 	proxyinformer "knative.dev/net-contour/pkg/client/injection/informers/projectcontour/v1/httpproxy"
+	delegationinformer "knative.dev/net-contour/pkg/client/injection/informers/projectcontour/v1/tlscertificatedelegation"
 	ingressclient "knative.dev/networking/pkg/client/injection/client"
 	ingressinformer "knative.dev/networking/pkg/client/injection/informers/networking/v1alpha1/ingress"
 	ingressreconciler "knative.dev/networking/pkg/client/injection/reconciler/networking/v1alpha1/ingress"
@@ -29,6 +31,7 @@ import (
 	serviceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/service"
 
 	"knative.dev/net-contour/pkg/reconciler/contour/config"
+	"knative.dev/net-contour/pkg/reconciler/contour/resources"
 	"knative.dev/networking/pkg/apis/networking"
 	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 	networkcfg "knative.dev/networking/pkg/config"
@@ -43,6 +46,54 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
+type targetNamespaceKey struct{}
+
+// WithTargetNamespace returns a context that restricts the controllers created
+// from it to the Ingresses in the given namespace. An empty namespace leaves
+// them unrestricted.
+func WithTargetNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, targetNamespaceKey{}, namespace)
+}
+
+func getTargetNamespace(ctx context.Context) string {
+	namespace, _ := ctx.Value(targetNamespaceKey{}).(string)
+	return namespace
+}
+
+// DefaultProxyWorkers is how many HTTPProxies are created or updated
+// concurrently for an Ingress, unless overridden with WithProxyWorkers.
+const DefaultProxyWorkers = 5
+
+type proxyWorkersKey struct{}
+
+// WithProxyWorkers returns a context that sets how many HTTPProxies the
+// controllers created from it create or update concurrently for an Ingress.
+func WithProxyWorkers(ctx context.Context, workers int) context.Context {
+	return context.WithValue(ctx, proxyWorkersKey{}, workers)
+}
+
+func getProxyWorkers(ctx context.Context) int {
+	if workers, ok := ctx.Value(proxyWorkersKey{}).(int); ok {
+		return workers
+	}
+	return DefaultProxyWorkers
+}
+
+// ingressFilterFunc returns the filter selecting the Ingresses to reconcile:
+// those of the Contour ingress class, restricted to targetNamespace when set.
+// Ingresses elsewhere belong to other controller instances and are never
+// enqueued.
+func ingressFilterFunc(targetNamespace string) func(interface{}) bool {
+	filter := reconciler.AnnotationFilterFunc(networking.IngressClassAnnotationKey, ContourIngressClassName, false)
+	if targetNamespace == "" {
+		return filter
+	}
+	// The informers stay cluster-wide: injection scopes them all at once,
+	// and probing needs the Envoy services and endpoints in Contour's
+	// namespaces, as checking delegations needs the secrets' namespaces.
+	return reconciler.ChainFilterFuncs(filter, reconciler.NamespaceFilterFunc(targetNamespace))
+}
+
 // NewController returns a new Ingress controller for Project Contour.
 func NewController(
 	ctx context.Context,
@@ -54,16 +105,19 @@ func NewController(
 	serviceInformer := serviceinformer.Get(ctx)
 	ingressInformer := ingressinformer.Get(ctx)
 	proxyInformer := proxyinformer.Get(ctx)
+	delegationInformer := delegationinformer.Get(ctx)
 	podInformer := podinformer.Get(ctx)
 
 	c := &Reconciler{
-		ingressClient: ingressclient.Get(ctx),
-		contourClient: contourclient.Get(ctx),
-		contourLister: proxyInformer.Lister(),
-		ingressLister: ingressInformer.Lister(),
-		serviceLister: serviceInformer.Lister(),
+		ingressClient:    ingressclient.Get(ctx),
+		contourClient:    contourclient.Get(ctx),
+		contourLister:    proxyInformer.Lister(),
+		delegationLister: delegationInformer.Lister(),
+		ingressLister:    ingressInformer.Lister(),
+		serviceLister:    serviceInformer.Lister(),
+		proxyWorkers:     getProxyWorkers(ctx),
 	}
-	myFilterFunc := reconciler.AnnotationFilterFunc(networking.IngressClassAnnotationKey, ContourIngressClassName, false)
+	myFilterFunc := ingressFilterFunc(getTargetNamespace(ctx))
 	impl := ingressreconciler.NewImpl(ctx, c, ContourIngressClassName,
 		func(impl *controller.Impl) controller.Options {
 			configsToResync := []interface{}{
@@ -98,6 +152,15 @@ func NewController(
 		Handler:    controller.HandleAll(impl.EnqueueControllerOf),
 	})
 
+	// Delegations can't be owned across namespaces, so the ones we create are
+	// labeled with the Ingress they were created for.
+	createdFilter := reconciler.LabelFilterFunc(resources.ManagedByKey, resources.ManagedByValue, false)
+	delegationInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: createdFilter,
+		Handler: controller.HandleAll(impl.EnqueueLabelOfNamespaceScopedResource(
+			resources.ParentNamespaceKey, resources.ParentKey)),
+	})
+
 	statusProber := status.NewProber(
 		logger.Named("status-manager"),
 		&lister{
@@ -128,6 +191,14 @@ func NewController(
 			corev1.SchemeGroupVersion.WithKind("Service"),
 		),
 	))
+	// The Ingresses relying on delegations created by hand track them.
+	delegationInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: reconciler.Not(createdFilter),
+		Handler: controller.HandleAll(controller.EnsureTypeMeta(
+			c.tracker.OnChanged,
+			contourv1.SchemeGroupVersion.WithKind("TLSCertificateDelegation"),
+		)),
+	})
 
 	return impl
 }
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	_ "knative.dev/net-contour/pkg/client/injection/informers/projectcontour/v1/httpproxy/fake"
+	_ "knative.dev/net-contour/pkg/client/injection/informers/projectcontour/v1/tlscertificatedelegation/fake"
 	_ "knative.dev/networking/pkg/client/injection/informers/networking/v1alpha1/ingress/fake"
 	_ "knative.dev/pkg/client/injection/kube/informers/core/v1/endpoints/fake"
 	_ "knative.dev/pkg/client/injection/kube/informers/core/v1/pod/fake"
@@ -28,6 +29,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"knative.dev/net-contour/pkg/reconciler/contour/config"
+	"knative.dev/networking/pkg/apis/networking"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 	networkcfg "knative.dev/networking/pkg/config"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/system"
@@ -54,3 +57,52 @@ func TestNew(t *testing.T) {
 		t.Fatal("Expected NewController to return a non-nil value")
 	}
 }
+
+func TestIngressFilterFunc(t *testing.T) {
+	ingress := func(namespace, class string) *v1alpha1.Ingress {
+		return &v1alpha1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      "name",
+				Annotations: map[string]string{
+					networking.IngressClassAnnotationKey: class,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		targetNamespace string
+		ing             *v1alpha1.Ingress
+		want            bool
+	}{{
+		name: "contour ingress",
+		ing:  ingress("ns", ContourIngressClassName),
+		want: true,
+	}, {
+		name: "other ingress class",
+		ing:  ingress("ns", "istio.ingress.networking.knative.dev"),
+	}, {
+		name:            "contour ingress in target namespace",
+		targetNamespace: "ns",
+		ing:             ingress("ns", ContourIngressClassName),
+		want:            true,
+	}, {
+		name:            "contour ingress outside target namespace",
+		targetNamespace: "ns",
+		ing:             ingress("other", ContourIngressClassName),
+	}, {
+		name:            "other ingress class in target namespace",
+		targetNamespace: "ns",
+		ing:             ingress("ns", "istio.ingress.networking.knative.dev"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ingressFilterFunc(test.targetNamespace)(test.ing); got != test.want {
+				t.Errorf("ingressFilterFunc(%q) = %v, wanted %v", test.targetNamespace, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	v1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/net-contour/pkg/reconciler/contour/resources/names"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+)
+
+// MakeCertificateDelegation creates a TLSCertificateDelegation in the secret's
+// namespace that allows the HTTPProxies of the Ingress to reference it. It has
+// no owner reference, since those cannot cross namespaces; the ManagedByKey,
+// ParentKey and ParentNamespaceKey labels mark it for cleanup instead.
+func MakeCertificateDelegation(ing *v1alpha1.Ingress, secretNamespace, secretName string) *v1.TLSCertificateDelegation {
+	return &v1.TLSCertificateDelegation{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: secretNamespace,
+			Name:      names.CertificateDelegation(ing, secretName),
+			Labels:    CertificateDelegationLabels(ing.Name, ing.Namespace),
+		},
+		Spec: v1.TLSCertificateDelegationSpec{
+			Delegations: []v1.CertificateDelegation{{
+				SecretName:       secretName,
+				TargetNamespaces: []string{ing.Namespace},
+			}},
+		},
+	}
+}
+
+// CertificateDelegationLabels returns the labels of the TLSCertificateDelegations
+// created for the named Ingress.
+func CertificateDelegationLabels(name, namespace string) map[string]string {
+	return map[string]string{
+		ManagedByKey:       ManagedByValue,
+		ParentKey:          name,
+		ParentNamespaceKey: namespace,
+	}
+}
+
+// IsCreatedForOtherIngress returns whether the delegation was created for an
+// Ingress other than ing, and may be deleted once that Ingress stops using it.
+func IsCreatedForOtherIngress(delegation *v1.TLSCertificateDelegation, ing *v1alpha1.Ingress) bool {
+	return delegation.Labels[ManagedByKey] == ManagedByValue &&
+		(delegation.Labels[ParentKey] != ing.Name || delegation.Labels[ParentNamespaceKey] != ing.Namespace)
+}
+
+// IsCertificateDelegated returns whether any of the delegations, which must
+// all live in the secret's namespace, allow targetNamespace to reference the
+// named secret.
+func IsCertificateDelegated(delegations []*v1.TLSCertificateDelegation, secretName, targetNamespace string) bool {
+	for _, delegation := range delegations {
+		for _, d := range delegation.Spec.Delegations {
+			if d.SecretName != secretName {
+				continue
+			}
+			for _, ns := range d.TargetNamespaces {
+				if ns == targetNamespace || ns == "*" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
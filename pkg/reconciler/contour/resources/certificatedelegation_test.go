@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/networking/pkg/apis/networking/v1alpha1"
+)
+
+func TestMakeCertificateDelegation(t *testing.T) {
+	want := &v1.TLSCertificateDelegation{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "secret-ns",
+			Name:      "name--foo--secret",
+			Labels: map[string]string{
+				ManagedByKey:       ManagedByValue,
+				ParentKey:          "name",
+				ParentNamespaceKey: "foo",
+			},
+		},
+		Spec: v1.TLSCertificateDelegationSpec{
+			Delegations: []v1.CertificateDelegation{{
+				SecretName:       "secret",
+				TargetNamespaces: []string{"foo"},
+			}},
+		},
+	}
+
+	got := MakeCertificateDelegation(delegationIngress("name", "foo"), "secret-ns", "secret")
+	if !cmp.Equal(want, got) {
+		t.Error("MakeCertificateDelegation (-want, +got) =", cmp.Diff(want, got))
+	}
+}
+
+func TestIsCertificateDelegated(t *testing.T) {
+	delegation := func(secretName, targetNamespace string) *v1.TLSCertificateDelegation {
+		d := MakeCertificateDelegation(delegationIngress("name", "foo"), "secret-ns", secretName)
+		d.Spec.Delegations[0].TargetNamespaces = []string{targetNamespace}
+		return d
+	}
+
+	tests := []struct {
+		name        string
+		delegations []*v1.TLSCertificateDelegation
+		want        bool
+	}{{
+		name: "no delegations",
+	}, {
+		name:        "delegated to namespace",
+		delegations: []*v1.TLSCertificateDelegation{delegation("secret", "foo")},
+		want:        true,
+	}, {
+		name:        "delegated to all namespaces",
+		delegations: []*v1.TLSCertificateDelegation{delegation("secret", "*")},
+		want:        true,
+	}, {
+		name:        "delegated to another namespace",
+		delegations: []*v1.TLSCertificateDelegation{delegation("secret", "bar")},
+	}, {
+		name:        "another secret delegated",
+		delegations: []*v1.TLSCertificateDelegation{delegation("other", "foo")},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsCertificateDelegated(test.delegations, "secret", "foo"); got != test.want {
+				t.Errorf("IsCertificateDelegated() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsCreatedForOtherIngress(t *testing.T) {
+	ing := delegationIngress("name", "foo")
+	tests := []struct {
+		name       string
+		delegation *v1.TLSCertificateDelegation
+		want       bool
+	}{{
+		name:       "created for the ingress",
+		delegation: MakeCertificateDelegation(ing, "secret-ns", "secret"),
+	}, {
+		name:       "created for another ingress",
+		delegation: MakeCertificateDelegation(delegationIngress("other", "foo"), "secret-ns", "secret"),
+		want:       true,
+	}, {
+		name:       "created for an ingress in another namespace",
+		delegation: MakeCertificateDelegation(delegationIngress("name", "bar"), "secret-ns", "secret"),
+		want:       true,
+	}, {
+		name: "created by hand",
+		delegation: &v1.TLSCertificateDelegation{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "secret-ns",
+				Name:      "by-hand",
+			},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsCreatedForOtherIngress(test.delegation, ing); got != test.want {
+				t.Errorf("IsCreatedForOtherIngress() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func delegationIngress(name, namespace string) *v1alpha1.Ingress {
+	return &v1alpha1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
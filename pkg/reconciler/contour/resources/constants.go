@@ -37,6 +37,17 @@ const (
 	// EndpointsProbeKey is placed on child Ingress resources to bypass Endpoint probing,
 	// since the child ingress exists to be said endpoint probe.
 	EndpointsProbeKey = "contour.networking.knative.dev/endpointsProbe"
+
+	// ParentNamespaceKey holds the namespace of the parent KIngress resource on
+	// resources created in other namespaces, alongside ParentKey.
+	ParentNamespaceKey = "contour.networking.knative.dev/parent-namespace"
+
+	// ManagedByKey is set to ManagedByValue on the TLSCertificateDelegations created
+	// for Ingresses, which cannot be owned by them across namespaces. Along with
+	// ParentKey and ParentNamespaceKey, it marks them for deletion once their
+	// Ingress no longer uses the delegated secret.
+	ManagedByKey   = "app.kubernetes.io/managed-by"
+	ManagedByValue = "net-contour"
 )
 
 const (
@@ -49,12 +60,128 @@ const (
 	//HttpChallengePath is the path that gets added to routes when using
 	//auto-TLS with an http01 solver as the issuer.
 	HTTPChallengePath = "/.well-known/acme-challenge"
+
+	// FractionalWeightScale is the factor split percentages are multiplied
+	// by when fractional weights are enabled.
+	FractionalWeightScale = 1000
 )
 
 // These are the annotations which are optionally set in ksvc/ingress
 const (
 	// If this annotation is set in ksvc/ingress, it is used as the ExternalService name in the
 	// generated HttpProxy
-	ExtensionServiceKey          = "contour.networking.knative.dev/extension-service"
-	ExtensionServiceNamespaceKey = "contour.networking.knative.dev/extension-service-namespace"
+	ExtensionServiceKey           = "contour.networking.knative.dev/extension-service"
+	ExtensionServiceNamespaceKey  = "contour.networking.knative.dev/extension-service-namespace"
+	ExtensionServiceAPIVersionKey = "contour.networking.knative.dev/extension-service-api-version"
+
+	// DefaultExtensionServiceAPIVersion is the ExtensionService API version used
+	// when ExtensionServiceAPIVersionKey is not set.
+	DefaultExtensionServiceAPIVersion = "projectcontour.io/v1alpha1"
+
+	// AuthExtensionsKey holds a comma-separated, priority-ordered list of
+	// ExtensionService names to use for authorization. It is only consulted
+	// when ExtensionServiceKey is not set, and currently only the first
+	// entry is used.
+	AuthExtensionsKey = "contour.networking.knative.dev/auth-extensions"
+
+	// AuthWithRequestBodyKey, when "true", has Envoy buffer the request body and
+	// forward it to the authorization service. Buffering holds every request in
+	// Envoy's memory until the authorization check completes, so large bodies
+	// raise both memory usage and latency; AuthMaxRequestBodyKey bounds the
+	// number of bytes buffered.
+	AuthWithRequestBodyKey = "contour.networking.knative.dev/auth-with-request-body"
+	AuthMaxRequestBodyKey  = "contour.networking.knative.dev/auth-max-request-body"
+
+	// AuthResponseTimeoutKey sets how long Envoy waits for the authorization
+	// service to respond. It must be shorter than the route response timeout.
+	AuthResponseTimeoutKey = "contour.networking.knative.dev/auth-response-timeout"
+
+	// AuthDisabledKeyPrefix is suffixed with the key of a path: the first 16
+	// hex digits of its SHA-256 digest, as printed by
+	// `printf %s /path | sha256sum | cut -c-16`. When "true", authorization is
+	// disabled on the routes for that path. Authorization is always disabled
+	// on ACME challenge paths.
+	AuthDisabledKeyPrefix = "contour.networking.knative.dev/auth-disabled-"
+
+	// AuthContextKeyPrefix is followed by "<key>-<path key>", where <path key>
+	// is derived from the path as for AuthDisabledKeyPrefix. The annotation
+	// value is passed to the authorization service as context entry <key> for
+	// that path's routes. Keys are limited to 33 characters, which keeps the
+	// annotation name within 63.
+	AuthContextKeyPrefix = "contour.networking.knative.dev/auth-context-"
+
+	// GRPCStreamingKey, when "true", marks the HTTP/2 services of the Ingress as
+	// serving streaming gRPC, and drops the retry conditions that are only safe
+	// to retry for unary calls.
+	GRPCStreamingKey = "contour.networking.knative.dev/grpc-streaming"
+
+	// RetryPerTryTimeoutKey bounds each attempt of a retried request. It must
+	// be shorter than the route response timeout, which bounds all attempts.
+	RetryPerTryTimeoutKey = "contour.networking.knative.dev/retry-per-try-timeout"
+
+	// RetryOnStatusKey is a comma-separated list of HTTP status codes, e.g.
+	// "503,504", on which requests are retried in addition to the default
+	// retry conditions.
+	RetryOnStatusKey = "contour.networking.knative.dev/retry-on-status"
+
+	// GRPCRetryOnKey is a comma-separated list of gRPC status code names, e.g.
+	// "UNAVAILABLE,DEADLINE_EXCEEDED", on which requests are retried in
+	// addition to the default retry conditions. Envoy can only retry on
+	// CANCELLED, DEADLINE_EXCEEDED, INTERNAL, RESOURCE_EXHAUSTED and
+	// UNAVAILABLE. With GRPCStreamingKey, CANCELLED and RESOURCE_EXHAUSTED
+	// are still not retried for HTTP/2 services.
+	GRPCRetryOnKey = "contour.networking.knative.dev/grpc-retry-on"
+
+	// UpstreamHTTPVersionKeyPrefix is suffixed with a backend service name and
+	// forces the HTTP version used to reach that service to HTTP1 or HTTP2,
+	// e.g. for services that speak HTTP/2 without advertising it over ALPN.
+	// AUTO, the default, keeps the protocol derived from the port name.
+	// Whether the connection is encrypted is not changed.
+	UpstreamHTTPVersionKeyPrefix = "contour.networking.knative.dev/upstream-http-version-"
+
+	// InvertHeaderMatchKeyPrefix is suffixed with a lowercased header name.
+	// When "true", routes match requests whose header value does NOT equal
+	// the exact value in the Ingress, including requests without the header.
+	// It is rejected for Knative's internal K-Network-* headers.
+	InvertHeaderMatchKeyPrefix = "contour.networking.knative.dev/invert-header-match-"
+
+	// RemoveResponseHeaderKeyPrefix is followed by "<service>-<header>". When
+	// "true", the header is removed from the responses of that backend
+	// service, unlike route-level header policies which apply to every
+	// service in the split. If several service names match, the longest wins.
+	RemoveResponseHeaderKeyPrefix = "contour.networking.knative.dev/h1-remove-response-header-"
+
+	// UpstreamTransportSocketKeyPrefix is suffixed with a backend service
+	// name and selects whether connections to it are encrypted: "tls" or
+	// "raw_buffer" (plaintext). The HTTP version is kept. Upstream
+	// certificate validation only applies to "tls".
+	UpstreamTransportSocketKeyPrefix = "contour.networking.knative.dev/upstream-transport-socket-"
+
+	// LoadBalancerStrategyKey sets the load balancing strategy of every route
+	// of the Ingress: Random, RoundRobin, WeightedLeastRequest, Cookie or
+	// RequestHash. Contour defaults to RoundRobin.
+	LoadBalancerStrategyKey = "contour.networking.knative.dev/lb-strategy"
+
+	// HashPolicyHeaderKeyPrefix is suffixed with a backend service name and
+	// hashes the requests of the routes to that service on the value of the
+	// named header. It requires the RequestHash strategy.
+	HashPolicyHeaderKeyPrefix = "contour.networking.knative.dev/hash-policy-header-"
+
+	// HashPolicyQueryKeyPrefix is suffixed with a backend service name and
+	// hashes the requests of the routes to that service on the value of the
+	// named query parameter. It holds a single parameter name and, like
+	// HashPolicyHeaderKeyPrefix, requires the RequestHash strategy.
+	HashPolicyQueryKeyPrefix = "contour.networking.knative.dev/hash-policy-query-"
+
+	// HashPolicySourceIPKeyPrefix is suffixed with a backend service name.
+	// When "true", the requests of the routes to that service are hashed on
+	// the client IP address, after any header or query parameter policy.
+	// Clients behind the same NAT or load balancer share its IP address and
+	// therefore all hash to the same backend.
+	HashPolicySourceIPKeyPrefix = "contour.networking.knative.dev/hash-policy-source-ip-"
+
+	// HashPolicyTerminalKeyPrefix is suffixed with a backend service name.
+	// When "true", the hash policies of that service are terminal: once one
+	// of them yields a hash, the policies after it are not evaluated.
+	HashPolicyTerminalKeyPrefix = "contour.networking.knative.dev/hash-policy-terminal-"
 )
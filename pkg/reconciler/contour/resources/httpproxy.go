@@ -20,9 +20,13 @@ import (
 	"context"
 	// nolint:gosec // No strong cryptography needed.
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	v1 "github.com/projectcontour/contour/apis/projectcontour/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -97,12 +101,606 @@ func defaultRetryPolicy() *v1.RetryPolicy {
 	}
 }
 
-func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtocol map[string]string) []*v1.HTTPProxy {
+// grpcStatusCodes are the names of the gRPC status codes.
+var grpcStatusCodes = sets.NewString(
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+)
+
+// grpcRetryConditions maps the gRPC status codes Envoy can retry on to their
+// retry conditions. gRPC errors are returned with an HTTP 200 status, so they
+// cannot be matched as retriable status codes.
+var grpcRetryConditions = map[string]v1.RetryOn{
+	"CANCELLED":          "cancelled",
+	"DEADLINE_EXCEEDED":  "deadline-exceeded",
+	"INTERNAL":           "internal",
+	"RESOURCE_EXHAUSTED": "resource-exhausted",
+	"UNAVAILABLE":        "unavailable",
+}
+
+// parseGRPCRetryOn parses the value of the annotation key as a
+// comma-separated list of gRPC status code names, returning their retry
+// conditions.
+func parseGRPCRetryOn(key, raw string) ([]v1.RetryOn, error) {
+	var retryOn []v1.RetryOn
+	for _, field := range strings.Split(raw, ",") {
+		name := strings.TrimSpace(field)
+		on, ok := grpcRetryConditions[name]
+		if !ok {
+			if grpcStatusCodes.Has(name) {
+				return nil, fmt.Errorf("%q: gRPC status code %s cannot be retried on", key, name)
+			}
+			return nil, fmt.Errorf("%q must only contain gRPC status code names, got %q", key, name)
+		}
+		retryOn = append(retryOn, on)
+	}
+	return retryOn, nil
+}
+
+// appendRetryOn adds the retry conditions in on to retry that it does not
+// already have.
+func appendRetryOn(retry *v1.RetryPolicy, on ...v1.RetryOn) {
+	for _, o := range on {
+		found := false
+		for _, existing := range retry.RetryOn {
+			if existing == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			retry.RetryOn = append(retry.RetryOn, o)
+		}
+	}
+}
+
+// removeStreamingUnsafeRetries removes the retry conditions from retry that are unsafe
+// for streaming gRPC calls, where part of the stream may already have been
+// processed by the upstream.
+func removeStreamingUnsafeRetries(retry *v1.RetryPolicy) {
+	retryOn := make([]v1.RetryOn, 0, len(retry.RetryOn))
+	for _, on := range retry.RetryOn {
+		switch on {
+		case "cancelled", "resource-exhausted":
+		default:
+			retryOn = append(retryOn, on)
+		}
+	}
+	retry.RetryOn = retryOn
+}
+
+// routePrefix returns the path prefix the route matches on, if any.
+func routePrefix(route v1.Route) string {
+	for _, cond := range route.Conditions {
+		if cond.Prefix != "" {
+			return cond.Prefix
+		}
+	}
+	return ""
+}
+
+// extensionServices returns the priority-ordered list of ExtensionService
+// names configured on the Ingress.
+func extensionServices(ing *v1alpha1.Ingress) []string {
+	if extensionService, ok := ing.Annotations[ExtensionServiceKey]; ok {
+		return []string{extensionService}
+	}
+	var names []string
+	for _, name := range strings.Split(ing.Annotations[AuthExtensionsKey], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// makeAuthorization returns the AuthorizationServer for the Ingress's virtual
+// hosts, or nil when no ExtensionService is configured.
+func makeAuthorization(ctx context.Context, ing *v1alpha1.Ingress) (*v1.AuthorizationServer, error) {
+	names := extensionServices(ing)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	// TODO(contour-chaining): Contour only accepts a single authorization
+	// server per virtual host. Once it supports chaining, the remaining
+	// entries of names become the fallbacks. Upstream tracking:
+	// https://github.com/projectcontour/contour/issues?q=is%3Aissue+external+authorization+multiple+servers
+	auth := &v1.AuthorizationServer{
+		ExtensionServiceRef: v1.ExtensionServiceReference{
+			APIVersion: DefaultExtensionServiceAPIVersion,
+			Name:       names[0],
+		},
+	}
+	if apiVersion, ok := ing.Annotations[ExtensionServiceAPIVersionKey]; ok {
+		auth.ExtensionServiceRef.APIVersion = apiVersion
+	}
+	if extensionServiceNamespace, ok := ing.Annotations[ExtensionServiceNamespaceKey]; ok {
+		auth.ExtensionServiceRef.Namespace = extensionServiceNamespace
+	}
+
+	if raw, ok := ing.Annotations[AuthWithRequestBodyKey]; ok {
+		withRequestBody, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", AuthWithRequestBodyKey, err)
+		}
+		if withRequestBody {
+			auth.WithRequestBody = &v1.AuthorizationServerBufferSettings{}
+			if raw, ok := ing.Annotations[AuthMaxRequestBodyKey]; ok {
+				maxBytes, err := parsePositiveUint32(AuthMaxRequestBodyKey, raw)
+				if err != nil {
+					return nil, err
+				}
+				auth.WithRequestBody.MaxRequestBytes = maxBytes
+			}
+		}
+	}
+
+	if raw, ok := ing.Annotations[AuthResponseTimeoutKey]; ok {
+		timeout, err := parsePositiveDuration(AuthResponseTimeoutKey, raw)
+		if err != nil {
+			return nil, err
+		}
+		// Otherwise every authorized request would time out before the route could respond.
+		if err := checkBelowRouteTimeout(ctx, AuthResponseTimeoutKey, raw, timeout); err != nil {
+			return nil, err
+		}
+		auth.ResponseTimeout = raw
+	}
+	return auth, nil
+}
+
+// parsePositiveDuration parses the value of the annotation key as a duration
+// greater than zero.
+func parsePositiveDuration(key, raw string) (time.Duration, error) {
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q: %w", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%q must be a positive duration, got %q", key, raw)
+	}
+	return d, nil
+}
+
+// checkBelowRouteTimeout checks that the duration d parsed from the value raw
+// of the annotation key is less than the route response timeout.
+func checkBelowRouteTimeout(ctx context.Context, key, raw string, d time.Duration) error {
+	if routeTimeout := config.FromContext(ctx).Contour.TimeoutPolicyResponse; routeTimeout != "infinity" {
+		if limit, err := time.ParseDuration(routeTimeout); err == nil && d >= limit {
+			return fmt.Errorf("%q must be less than the route response timeout %s, got %q", key, routeTimeout, raw)
+		}
+	}
+	return nil
+}
+
+// parseRetriableStatusCodes parses the value of the annotation key as a
+// comma-separated list of HTTP status codes, returned sorted and deduplicated.
+func parseRetriableStatusCodes(key, raw string) ([]uint32, error) {
+	codes := sets.NewInt()
+	for _, field := range strings.Split(raw, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("%q must only contain status codes in [100, 599], got %d", key, code)
+		}
+		codes.Insert(code)
+	}
+
+	statusCodes := make([]uint32, 0, codes.Len())
+	for _, code := range codes.List() {
+		statusCodes = append(statusCodes, uint32(code))
+	}
+	return statusCodes, nil
+}
+
+// pathKey returns the fixed-length key that stands for path in annotation
+// names: the first 16 hex digits of the SHA-256 digest of path. Unlike an
+// encoding of the path itself, it stays within the 63 character limit on
+// annotation names however long the path is, and always ends with a valid
+// character.
+func pathKey(path string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(path)))[:16]
+}
+
+// authContexts returns the authorization context entries configured on the
+// Ingress, keyed by path.
+func authContexts(ing *v1alpha1.Ingress) map[string]map[string]string {
+	paths := make(map[string]string)
+	for _, rule := range ing.Spec.Rules {
+		for _, path := range rule.HTTP.Paths {
+			paths[pathKey(path.Path)] = path.Path
+		}
+	}
+
+	contexts := make(map[string]map[string]string)
+	for annotation, value := range ing.Annotations {
+		if !strings.HasPrefix(annotation, AuthContextKeyPrefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(annotation, AuthContextKeyPrefix)
+		i := strings.LastIndex(rest, "-")
+		if i < 1 {
+			continue
+		}
+		path, ok := paths[rest[i+1:]]
+		if !ok {
+			continue
+		}
+		if contexts[path] == nil {
+			contexts[path] = make(map[string]string)
+		}
+		contexts[path][rest[:i]] = value
+	}
+	return contexts
+}
+
+// removedResponseHeaders returns the sorted response headers to remove for
+// each backend service of the Ingress, as configured with
+// RemoveResponseHeaderKeyPrefix.
+func removedResponseHeaders(ing *v1alpha1.Ingress) (map[string][]string, error) {
+	services := sets.NewString()
+	for _, rule := range ing.Spec.Rules {
+		for _, path := range rule.HTTP.Paths {
+			for _, split := range path.Splits {
+				services.Insert(split.ServiceName)
+			}
+		}
+	}
+
+	removed := make(map[string]sets.String)
+	for annotation, value := range ing.Annotations {
+		if !strings.HasPrefix(annotation, RemoveResponseHeaderKeyPrefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(annotation, RemoveResponseHeaderKeyPrefix)
+		match := ""
+		for service := range services {
+			if len(service) > len(match) && len(rest) > len(service)+1 && strings.HasPrefix(rest, service+"-") {
+				match = service
+			}
+		}
+		if match == "" {
+			continue
+		}
+		remove, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", annotation, err)
+		}
+		if !remove {
+			continue
+		}
+		if removed[match] == nil {
+			removed[match] = sets.NewString()
+		}
+		// Header names are case-insensitive.
+		removed[match].Insert(strings.ToLower(rest[len(match)+1:]))
+	}
+
+	headers := make(map[string][]string, len(removed))
+	for service, names := range removed {
+		headers[service] = names.List()
+	}
+	return headers, nil
+}
+
+// makeAuthPolicy returns the route-level AuthorizationPolicy for the given
+// path, or nil when the route inherits the virtual host's authorization.
+func makeAuthPolicy(ing *v1alpha1.Ingress, path string, context map[string]string) (*v1.AuthorizationPolicy, error) {
+	policy := &v1.AuthorizationPolicy{
+		Context: context,
+	}
+
+	key := AuthDisabledKeyPrefix + pathKey(path)
+	if raw, ok := ing.Annotations[key]; ok {
+		disabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+		policy.Disabled = disabled
+	}
+
+	// ACME challenges must be reachable without authenticating.
+	if strings.Contains(path, HTTPChallengePath) {
+		policy.Disabled = true
+	}
+
+	if !policy.Disabled && len(policy.Context) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// parsePositiveUint32 parses the value of the annotation key as an integer
+// greater than zero.
+func parsePositiveUint32(key, raw string) (uint32, error) {
+	v, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q: %w", key, err)
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("%q must be a positive integer, got %q", key, raw)
+	}
+	return uint32(v), nil
+}
+
+// upstreamProtocol applies the UpstreamHTTPVersionKeyPrefix annotation for
+// serviceName, if any, to the protocol otherwise used to reach the service.
+func upstreamProtocol(ing *v1alpha1.Ingress, serviceName string, protocol *string) (*string, error) {
+	key := UpstreamHTTPVersionKeyPrefix + serviceName
+	version, ok := ing.Annotations[key]
+	if !ok {
+		return protocol, nil
+	}
+
+	encrypted := protocol != nil &&
+		(*protocol == InternalEncryptionProtocol || *protocol == InternalEncryptionH2Protocol)
+	switch version {
+	case "AUTO":
+		return protocol, nil
+	case "HTTP1":
+		if encrypted {
+			return ptr.String(InternalEncryptionProtocol), nil
+		}
+		return nil, nil
+	case "HTTP2":
+		if encrypted {
+			return ptr.String(InternalEncryptionH2Protocol), nil
+		}
+		return ptr.String("h2c"), nil
+	default:
+		return nil, fmt.Errorf("%q must be one of HTTP1, HTTP2 or AUTO, got %q", key, version)
+	}
+}
+
+// applyUpstreamTransport applies the UpstreamTransportSocketKeyPrefix
+// annotation for svc, if any, switching it between encrypted and plaintext
+// variants of its protocol.
+func applyUpstreamTransport(ing *v1alpha1.Ingress, svc *v1.Service) error {
+	key := UpstreamTransportSocketKeyPrefix + svc.Name
+	transport, ok := ing.Annotations[key]
+	if !ok {
+		return nil
+	}
+
+	h2 := svc.Protocol != nil && (*svc.Protocol == "h2c" || *svc.Protocol == InternalEncryptionH2Protocol)
+	switch transport {
+	case "tls":
+		if h2 {
+			svc.Protocol = ptr.String(InternalEncryptionH2Protocol)
+		} else {
+			svc.Protocol = ptr.String(InternalEncryptionProtocol)
+		}
+	case "raw_buffer":
+		if h2 {
+			svc.Protocol = ptr.String("h2c")
+		} else {
+			svc.Protocol = nil
+		}
+		svc.UpstreamValidation = nil
+	default:
+		return fmt.Errorf("%q must be one of tls or raw_buffer, got %q", key, transport)
+	}
+	return nil
+}
+
+// makeLoadBalancerPolicy returns the load balancing policy of a route
+// splitting traffic across services, or nil to use Contour's default.
+func makeLoadBalancerPolicy(ing *v1alpha1.Ingress, services []v1.Service) (*v1.LoadBalancerPolicy, error) {
+	var hashPolicies []v1.RequestHashPolicy
+	terminalKey := ""
+	seen := sets.NewString()
+	for _, svc := range services {
+		if seen.Has(svc.Name) {
+			continue
+		}
+		seen.Insert(svc.Name)
+
+		var policies []v1.RequestHashPolicy
+		key := HashPolicyHeaderKeyPrefix + svc.Name
+		if header, ok := ing.Annotations[key]; ok {
+			if header == "" {
+				return nil, fmt.Errorf("%q must not be empty", key)
+			}
+			policies = append(policies, v1.RequestHashPolicy{
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: header},
+			})
+		}
+
+		key = HashPolicyQueryKeyPrefix + svc.Name
+		if param, ok := ing.Annotations[key]; ok {
+			// Parameter names are matched unescaped, so only accept those
+			// that need no escaping.
+			if param == "" || url.QueryEscape(param) != param {
+				return nil, fmt.Errorf("%q must be a non-empty URL-safe query parameter name, got %q", key, param)
+			}
+			policies = append(policies, v1.RequestHashPolicy{
+				QueryParameterHashOptions: &v1.QueryParameterHashOptions{ParameterName: param},
+			})
+		}
+
+		key = HashPolicySourceIPKeyPrefix + svc.Name
+		if raw, ok := ing.Annotations[key]; ok {
+			sourceIP, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q: %w", key, err)
+			}
+			if sourceIP {
+				policies = append(policies, v1.RequestHashPolicy{HashSourceIP: true})
+			}
+		}
+
+		key = HashPolicyTerminalKeyPrefix + svc.Name
+		if raw, ok := ing.Annotations[key]; ok {
+			terminal, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q: %w", key, err)
+			}
+			if terminal {
+				if len(policies) == 0 {
+					return nil, fmt.Errorf("%q requires a hash policy for %q", key, svc.Name)
+				}
+				for i := range policies {
+					policies[i].Terminal = true
+				}
+				terminalKey = key
+			}
+		}
+		hashPolicies = append(hashPolicies, policies...)
+	}
+	// A terminal policy only skips the policies after it.
+	if terminalKey != "" && len(hashPolicies) < 2 {
+		return nil, fmt.Errorf("%q requires multiple hash policies on the route", terminalKey)
+	}
+
+	strategy, ok := ing.Annotations[LoadBalancerStrategyKey]
+	switch strategy {
+	case "Random", "RoundRobin", "WeightedLeastRequest", "Cookie", "RequestHash":
+	default:
+		if ok {
+			return nil, fmt.Errorf("%q must be one of Random, RoundRobin, WeightedLeastRequest, Cookie or RequestHash, got %q", LoadBalancerStrategyKey, strategy)
+		}
+	}
+	// Contour ignores hash policies under every other strategy.
+	if len(hashPolicies) > 0 && strategy != "RequestHash" {
+		return nil, fmt.Errorf("hash policies require %q to be RequestHash, got %q", LoadBalancerStrategyKey, strategy)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &v1.LoadBalancerPolicy{
+		Strategy:            strategy,
+		RequestHashPolicies: hashPolicies,
+	}, nil
+}
+
+// networkHeaderPrefix is the lowercased prefix of the headers Knative's
+// networking layer uses internally, such as K-Network-Hash.
+const networkHeaderPrefix = "k-network-"
+
+// makeHeaderMatchCondition returns the condition matching the named header
+// against match, inverted when InvertHeaderMatchKeyPrefix is set for it.
+//
+// An empty exact value is rejected. Exact is omitted from the serialized
+// condition when empty, and Contour drops header conditions without a match,
+// so the route would match every request, with or without the header.
+//
+// Inverting a K-Network-* header is rejected: Knative sets these internally,
+// e.g. K-Network-Hash on probes, and an inverted match on them would route
+// those requests, which carry no other header, to the inverted route.
+func makeHeaderMatchCondition(ing *v1alpha1.Ingress, name string, match v1alpha1.HeaderMatch) (*v1.HeaderMatchCondition, error) {
+	if match.Exact == "" {
+		return nil, fmt.Errorf("header %q requires a non-empty exact match value", name)
+	}
+	key := InvertHeaderMatchKeyPrefix + strings.ToLower(name)
+	raw, ok := ing.Annotations[key]
+	if !ok {
+		return &v1.HeaderMatchCondition{Name: name, Exact: match.Exact}, nil
+	}
+	if strings.HasPrefix(strings.ToLower(name), networkHeaderPrefix) {
+		return nil, fmt.Errorf("%q cannot invert the match of network-internal header %q", key, name)
+	}
+	invert, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", key, err)
+	}
+	if !invert {
+		return &v1.HeaderMatchCondition{Name: name, Exact: match.Exact}, nil
+	}
+	return &v1.HeaderMatchCondition{Name: name, NotExact: match.Exact}, nil
+}
+
+// validateFQDN checks that fqdn is a DNS name Contour will accept as a virtual
+// host: at most 253 characters of dot-separated RFC 1123 labels, each 1-63
+// lowercase alphanumerics or hyphens that do not start or end with a hyphen.
+// The first label may instead be a "*" wildcard. Unlike RFC 1123, uppercase
+// letters are rejected, as they are by the pattern of the Fqdn field.
+func validateFQDN(fqdn string) error {
+	if len(fqdn) > 253 {
+		return fmt.Errorf("invalid fqdn %q: must be no more than 253 characters", fqdn)
+	}
+	for i, label := range strings.Split(fqdn, ".") {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("invalid fqdn %q: label %q must be 1-63 characters", fqdn, label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid fqdn %q: label %q must not start or end with a hyphen", fqdn, label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid fqdn %q: label %q must consist of lowercase alphanumerics and hyphens", fqdn, label)
+			}
+		}
+	}
+	return nil
+}
+
+// safeProxyName returns the name of the HTTPProxy for the given Ingress,
+// visibility class and host. Ingress names and hosts can together exceed the
+// limits on object names, so this relies on kmeta.ChildName, which keeps
+// names of up to 63 characters and truncates longer ones to 63 characters
+// with a hash of the full name, keeping them unique and deterministic.
+func safeProxyName(ingName, class, host string) string {
+	return kmeta.ChildName(ingName+"-"+class+"-", host)
+}
+
+func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtocol map[string]string) ([]*v1.HTTPProxy, error) {
 	cfg := config.FromContext(ctx)
 
 	ing = ing.DeepCopy()
 	ingress.InsertProbe(ing)
 
+	auth, err := makeAuthorization(ctx, ing)
+	if err != nil {
+		return nil, err
+	}
+	contexts := authContexts(ing)
+	removedHeaders, err := removedResponseHeaders(ing)
+	if err != nil {
+		return nil, err
+	}
+
+	var grpcStreaming bool
+	if raw, ok := ing.Annotations[GRPCStreamingKey]; ok {
+		if grpcStreaming, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", GRPCStreamingKey, err)
+		}
+	}
+
+	var perTryTimeout string
+	if raw, ok := ing.Annotations[RetryPerTryTimeoutKey]; ok {
+		timeout, err := parsePositiveDuration(RetryPerTryTimeoutKey, raw)
+		if err != nil {
+			return nil, err
+		}
+		// Otherwise no retry could start before the route times out.
+		if err := checkBelowRouteTimeout(ctx, RetryPerTryTimeoutKey, raw, timeout); err != nil {
+			return nil, err
+		}
+		perTryTimeout = raw
+	}
+
+	var retriableStatusCodes []uint32
+	if raw, ok := ing.Annotations[RetryOnStatusKey]; ok {
+		if retriableStatusCodes, err = parseRetriableStatusCodes(RetryOnStatusKey, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var grpcRetryOn []v1.RetryOn
+	if raw, ok := ing.Annotations[GRPCRetryOnKey]; ok {
+		if grpcRetryOn, err = parseGRPCRetryOn(GRPCRetryOnKey, raw); err != nil {
+			return nil, err
+		}
+	}
+
 	hostToTLS := make(map[string]v1alpha1.IngressTLS, len(ing.Spec.TLS))
 	for _, tls := range ing.Spec.TLS {
 		for _, host := range tls.Hosts {
@@ -136,6 +734,9 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 			// https://istio.io/latest/docs/concepts/traffic-management/#retries
 			// However, in addition to the codes specified by istio
 			retry := defaultRetryPolicy()
+			retry.PerTryTimeout = perTryTimeout
+			retry.RetriableStatusCodes = retriableStatusCodes
+			appendRetryOn(retry, grpcRetryOn...)
 
 			preSplitHeaders := &v1.HeadersPolicy{
 				Set: make([]v1.HeaderValue, 0, len(path.AppendHeaders)),
@@ -162,10 +763,14 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 			svcs := make([]v1.Service, 0, len(path.Splits))
 			for _, split := range path.Splits {
 
+				weight := int64(split.Percent)
+				if cfg.Contour.UseFractionalWeights {
+					weight *= FractionalWeightScale
+				}
 				svc := v1.Service{
 					Name:   split.ServiceName,
 					Port:   split.ServicePort.IntValue(),
-					Weight: int64(split.Percent),
+					Weight: weight,
 				}
 
 				postSplitHeaders := &v1.HeadersPolicy{
@@ -191,6 +796,9 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 				}
 
 				svc.RequestHeadersPolicy = postSplitHeaders
+				if remove := removedHeaders[split.ServiceName]; len(remove) > 0 {
+					svc.ResponseHeadersPolicy = &v1.HeadersPolicy{Remove: remove}
+				}
 
 				if proto, ok := serviceToProtocol[split.ServiceName]; ok {
 					//In order for domain mappings to work with internal
@@ -207,6 +815,10 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 					}
 				}
 
+				if svc.Protocol, err = upstreamProtocol(ing, split.ServiceName, svc.Protocol); err != nil {
+					return nil, err
+				}
+
 				if cfg.Network != nil && cfg.Network.InternalEncryption {
 					svc.UpstreamValidation = &v1.UpstreamValidation{
 						CACertificate: fmt.Sprintf("%s/%s", system.Namespace(), netcfg.ServingInternalCertName),
@@ -214,6 +826,10 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 					}
 				}
 
+				if err := applyUpstreamTransport(ing, &svc); err != nil {
+					return nil, err
+				}
+
 				if strings.Contains(path.Path, HTTPChallengePath) {
 					//make sure http01 challenge doesn't get encrypted or use http2
 					svc.Protocol = nil
@@ -223,6 +839,29 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 				svcs = append(svcs, svc)
 			}
 
+			if grpcStreaming {
+				for _, svc := range svcs {
+					if svc.Protocol != nil && (*svc.Protocol == "h2c" || *svc.Protocol == InternalEncryptionH2Protocol) {
+						removeStreamingUnsafeRetries(retry)
+						break
+					}
+				}
+			}
+
+			lbPolicy, err := makeLoadBalancerPolicy(ing, svcs)
+			if err != nil {
+				return nil, err
+			}
+
+			// Route-level authorization only applies when the virtual
+			// host has an authorization server.
+			var authPolicy *v1.AuthorizationPolicy
+			if auth != nil {
+				if authPolicy, err = makeAuthPolicy(ing, path.Path, contexts[path.Path]); err != nil {
+					return nil, err
+				}
+			}
+
 			var conditions []v1.MatchCondition
 			if path.Path != "" {
 				conditions = append(conditions, v1.MatchCondition{
@@ -230,11 +869,12 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 				})
 			}
 			for header, match := range path.Headers {
+				cond, err := makeHeaderMatchCondition(ing, header, match)
+				if err != nil {
+					return nil, err
+				}
 				conditions = append(conditions, v1.MatchCondition{
-					Header: &v1.HeaderMatchCondition{
-						Name:  header,
-						Exact: match.Exact,
-					},
+					Header: cond,
 				})
 			}
 
@@ -257,8 +897,10 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 			}
 			routes = append(routes, v1.Route{
 				Conditions:           conditions,
+				AuthPolicy:           authPolicy,
 				TimeoutPolicy:        top,
 				RetryPolicy:          retry,
+				LoadBalancerPolicy:   lbPolicy,
 				Services:             svcs,
 				EnableWebsockets:     true,
 				RequestHeadersPolicy: preSplitHeaders,
@@ -266,6 +908,13 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 			})
 		}
 
+		// Check more specific (longer) prefixes first. The sort is stable so
+		// routes with equal prefixes, such as the probe route inserted ahead of
+		// the path it probes, keep their relative order.
+		sort.SliceStable(routes, func(i, j int) bool {
+			return len(routePrefix(routes[i])) > len(routePrefix(routes[j]))
+		})
+
 		base := v1.HTTPProxy{
 			ObjectMeta: metav1.ObjectMeta{
 				Namespace: ing.Namespace,
@@ -298,22 +947,16 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 					hostProxy.Labels[ClassKey] = class
 				}
 
-				hostProxy.Name = kmeta.ChildName(ing.Name+"-"+class+"-", host)
+				if err := validateFQDN(host); err != nil {
+					return nil, err
+				}
+				hostProxy.Name = safeProxyName(ing.Name, class, host)
 				hostProxy.Spec.VirtualHost = &v1.VirtualHost{
 					Fqdn: host,
 				}
 
 				// Set ExtensionService if annotation is present
-				if extensionService, ok := ing.Annotations[ExtensionServiceKey]; ok {
-					hostProxy.Spec.VirtualHost.Authorization = &v1.AuthorizationServer{}
-					hostProxy.Spec.VirtualHost.Authorization.ExtensionServiceRef = v1.ExtensionServiceReference{
-						Name: extensionService,
-					}
-
-					if extensionServiceNamespace, ok := ing.Annotations[ExtensionServiceNamespaceKey]; ok {
-						hostProxy.Spec.VirtualHost.Authorization.ExtensionServiceRef.Namespace = extensionServiceNamespace
-					}
-				}
+				hostProxy.Spec.VirtualHost.Authorization = auth.DeepCopy()
 
 				// nolint:gosec // No strong cryptography needed.
 				hostProxy.Labels[DomainHashKey] = fmt.Sprintf("%x", sha1.Sum([]byte(host)))
@@ -332,5 +975,38 @@ func MakeHTTPProxies(ctx context.Context, ing *v1alpha1.Ingress, serviceToProtoc
 		}
 	}
 
-	return proxies
+	return proxies, nil
+}
+
+// AggregateProxyStatuses returns whether Contour has accepted every one of the
+// proxies generated for an Ingress, or describes the first of them it has
+// rejected. Statuses Contour recorded for an earlier generation of a proxy,
+// such as those of a proxy that was just updated, count as not yet processed,
+// as do missing ones.
+func AggregateProxyStatuses(proxies []*v1.HTTPProxy) (ready bool, message string) {
+	ready = true
+	for _, proxy := range proxies {
+		valid := false
+		for _, cond := range proxy.Status.Conditions {
+			if cond.Type != v1.ValidConditionType {
+				continue
+			}
+			if cond.ObservedGeneration != proxy.Generation {
+				break
+			}
+			if cond.Status == v1.ConditionFalse {
+				// Contour summarizes multiple errors as "MultipleReasons"
+				// in the condition, so prefer the first detailed error.
+				reason := cond.Message
+				if len(cond.Errors) > 0 {
+					reason = cond.Errors[0].Message
+				}
+				return false, fmt.Sprintf("HTTPProxy %q is invalid: %s", proxy.Name, reason)
+			}
+			valid = cond.Status == v1.ConditionTrue
+			break
+		}
+		ready = ready && valid
+	}
+	return ready, ""
 }
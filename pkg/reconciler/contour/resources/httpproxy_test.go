@@ -18,7 +18,9 @@ package resources
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"knative.dev/pkg/system"
@@ -30,6 +32,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/net-contour/pkg/reconciler/contour/config"
 	"knative.dev/networking/pkg/apis/networking/v1alpha1"
 	netcfg "knative.dev/networking/pkg/config"
@@ -1774,8 +1777,9 @@ func TestMakeProxies(t *testing.T) {
 					Fqdn: "example.com",
 					Authorization: &v1.AuthorizationServer{
 						ExtensionServiceRef: v1.ExtensionServiceReference{
-							Name:      "es",
-							Namespace: "es-ns",
+							APIVersion: DefaultExtensionServiceAPIVersion,
+							Name:       "es",
+							Namespace:  "es-ns",
 						},
 					},
 				},
@@ -1837,51 +1841,8 @@ func TestMakeProxies(t *testing.T) {
 				}},
 			},
 		}},
-	}}
-
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			config := &config.Config{
-				Contour: &config.Contour{
-					VisibilityClasses: map[v1alpha1.IngressVisibility]string{
-						v1alpha1.IngressVisibilityClusterLocal: privateClass,
-						v1alpha1.IngressVisibilityExternalIP:   publicClass,
-					},
-					TimeoutPolicyResponse: "infinity",
-					TimeoutPolicyIdle:     "infinity",
-				},
-			}
-
-			if test.modifyConfig != nil {
-				test.modifyConfig(config)
-			}
-
-			tcs := &testConfigStore{config: config}
-			ctx := tcs.ToContext(context.Background())
-
-			got := MakeHTTPProxies(ctx, test.ing, serviceToProtocol)
-			if !cmp.Equal(test.want, got) {
-				t.Error("MakeHTTPProxies (-want, +got) =", cmp.Diff(test.want, got))
-			}
-		})
-	}
-}
-
-func TestMakeProxiesInternalEncryption(t *testing.T) {
-	tlsProto := InternalEncryptionProtocol
-	h2Proto := InternalEncryptionH2Protocol
-	serviceToProtocol := map[string]string{
-		"goo":  tlsProto,
-		"htwo": h2Proto,
-	}
-
-	tests := []struct {
-		name         string
-		ing          *v1alpha1.Ingress
-		want         []*v1.HTTPProxy
-		modifyConfig func(*config.Config)
-	}{{
-		name: "single external domain with internal encryption enabled",
+	}, {
+		name: "paths ordered from the longest prefix",
 		ing: &v1alpha1.Ingress{
 			ObjectMeta: metav1.ObjectMeta{
 				Namespace: "foo",
@@ -1894,20 +1855,30 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					Visibility: v1alpha1.IngressVisibilityExternalIP,
 					HTTP: &v1alpha1.HTTPIngressRuleValue{
 						Paths: []v1alpha1.HTTPIngressPath{{
-							AppendHeaders: map[string]string{
-								"Foo": "bar",
-							},
 							Splits: []v1alpha1.IngressBackendSplit{{
 								IngressBackend: v1alpha1.IngressBackend{
-									ServiceName:      "goo",
-									ServiceNamespace: "foo",
-									ServicePort:      intstr.FromInt(123),
+									ServiceName: "goo",
+									ServicePort: intstr.FromInt(123),
 								},
 								Percent: 100,
-								AppendHeaders: map[string]string{
-									"Baz":   "blah",
-									"Bleep": "bloop",
+							}},
+						}, {
+							Path: "/api",
+							Splits: []v1alpha1.IngressBackendSplit{{
+								IngressBackend: v1alpha1.IngressBackend{
+									ServiceName: "goo",
+									ServicePort: intstr.FromInt(123),
+								},
+								Percent: 100,
+							}},
+						}, {
+							Path: "/api/v2",
+							Splits: []v1alpha1.IngressBackendSplit{{
+								IngressBackend: v1alpha1.IngressBackend{
+									ServiceName: "goo",
+									ServicePort: intstr.FromInt(123),
 								},
+								Percent: 100,
 							}},
 						}},
 					},
@@ -1940,6 +1911,37 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					Fqdn: "example.com",
 				},
 				Routes: []v1.Route{{
+					Conditions: []v1.MatchCondition{{
+						Prefix: "/api/v2",
+					}, {
+						Header: &v1.HeaderMatchCondition{
+							Name:  "K-Network-Hash",
+							Exact: "override",
+						},
+					}},
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{{
+							Name:  "K-Network-Hash",
+							Value: "492e6aee23aee0984f4cae82857762bfce1e1370b50f904681a80f2e41585e95",
+						}},
+					},
+					Services: []v1.Service{{
+						Name:     "goo",
+						Protocol: &protocol,
+						Port:     123,
+						Weight:   100,
+					}},
+				}, {
+					Conditions: []v1.MatchCondition{{
+						Prefix: "/api/v2",
+					}},
 					EnableWebsockets: true,
 					PermitInsecure:   true,
 					TimeoutPolicy: &v1.TimeoutPolicy{
@@ -1947,41 +1949,70 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 						Idle:     "infinity",
 					},
 					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{},
+					},
+					Services: []v1.Service{{
+						Name:     "goo",
+						Protocol: &protocol,
+						Port:     123,
+						Weight:   100,
+					}},
+				}, {
 					Conditions: []v1.MatchCondition{{
+						Prefix: "/api",
+					}, {
 						Header: &v1.HeaderMatchCondition{
 							Name:  "K-Network-Hash",
 							Exact: "override",
 						},
 					}},
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					RetryPolicy: defaultRetryPolicy(),
 					RequestHeadersPolicy: &v1.HeadersPolicy{
 						Set: []v1.HeaderValue{{
-							Name:  "Foo",
-							Value: "bar",
-						}, {
 							Name:  "K-Network-Hash",
-							Value: "6d6a4e524d201b156fae9b8d16e5dc534c6a2c18ca612061c9a5ac4b797affbb",
+							Value: "492e6aee23aee0984f4cae82857762bfce1e1370b50f904681a80f2e41585e95",
 						}},
 					},
 					Services: []v1.Service{{
 						Name:     "goo",
+						Protocol: &protocol,
 						Port:     123,
-						Protocol: &tlsProto,
-						UpstreamValidation: &v1.UpstreamValidation{
-							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
-							SubjectName:   "data-plane.knative.dev",
-						},
-						Weight: 100,
-						RequestHeadersPolicy: &v1.HeadersPolicy{
-							Set: []v1.HeaderValue{{
-								Name:  "Baz",
-								Value: "blah",
-							}, {
-								Name:  "Bleep",
-								Value: "bloop",
-							}},
-						},
+						Weight:   100,
+					}},
+				}, {
+					Conditions: []v1.MatchCondition{{
+						Prefix: "/api",
+					}},
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{},
+					},
+					Services: []v1.Service{{
+						Name:     "goo",
+						Protocol: &protocol,
+						Port:     123,
+						Weight:   100,
 					}},
 				}, {
+					Conditions: []v1.MatchCondition{{
+						Header: &v1.HeaderMatchCondition{
+							Name:  "K-Network-Hash",
+							Exact: "override",
+						},
+					}},
 					EnableWebsockets: true,
 					PermitInsecure:   true,
 					TimeoutPolicy: &v1.TimeoutPolicy{
@@ -1991,34 +2022,38 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					RetryPolicy: defaultRetryPolicy(),
 					RequestHeadersPolicy: &v1.HeadersPolicy{
 						Set: []v1.HeaderValue{{
-							Name:  "Foo",
-							Value: "bar",
+							Name:  "K-Network-Hash",
+							Value: "492e6aee23aee0984f4cae82857762bfce1e1370b50f904681a80f2e41585e95",
 						}},
 					},
 					Services: []v1.Service{{
 						Name:     "goo",
+						Protocol: &protocol,
 						Port:     123,
-						Protocol: &tlsProto,
-						UpstreamValidation: &v1.UpstreamValidation{
-							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
-							SubjectName:   "data-plane.knative.dev",
-						},
-						Weight: 100,
-						RequestHeadersPolicy: &v1.HeadersPolicy{
-							Set: []v1.HeaderValue{{
-								Name:  "Baz",
-								Value: "blah",
-							}, {
-								Name:  "Bleep",
-								Value: "bloop",
-							}},
-						},
+						Weight:   100,
+					}},
+				}, {
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{},
+					},
+					Services: []v1.Service{{
+						Name:     "goo",
+						Protocol: &protocol,
+						Port:     123,
+						Weight:   100,
 					}},
 				}},
 			},
 		}},
 	}, {
-		name: "single external domain with internal encryption enabled and http/2",
+		name: "path matching only on headers",
 		ing: &v1alpha1.Ingress{
 			ObjectMeta: metav1.ObjectMeta{
 				Namespace: "foo",
@@ -2031,20 +2066,20 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					Visibility: v1alpha1.IngressVisibilityExternalIP,
 					HTTP: &v1alpha1.HTTPIngressRuleValue{
 						Paths: []v1alpha1.HTTPIngressPath{{
-							AppendHeaders: map[string]string{
-								"Foo": "bar",
+							Headers: map[string]v1alpha1.HeaderMatch{
+								"a-header": {
+									Exact: "a",
+								},
+								"b-header": {
+									Exact: "b",
+								},
 							},
 							Splits: []v1alpha1.IngressBackendSplit{{
 								IngressBackend: v1alpha1.IngressBackend{
-									ServiceName:      "htwo",
-									ServiceNamespace: "foo",
-									ServicePort:      intstr.FromInt(123),
+									ServiceName: "goo",
+									ServicePort: intstr.FromInt(123),
 								},
 								Percent: 100,
-								AppendHeaders: map[string]string{
-									"Baz":   "blah",
-									"Bleep": "bloop",
-								},
 							}},
 						}},
 					},
@@ -2077,6 +2112,22 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					Fqdn: "example.com",
 				},
 				Routes: []v1.Route{{
+					Conditions: []v1.MatchCondition{{
+						Header: &v1.HeaderMatchCondition{
+							Name:  "b-header",
+							Exact: "b",
+						},
+					}, {
+						Header: &v1.HeaderMatchCondition{
+							Name:  "a-header",
+							Exact: "a",
+						},
+					}, {
+						Header: &v1.HeaderMatchCondition{
+							Name:  "K-Network-Hash",
+							Exact: "override",
+						},
+					}},
 					EnableWebsockets: true,
 					PermitInsecure:   true,
 					TimeoutPolicy: &v1.TimeoutPolicy{
@@ -2084,41 +2135,30 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 						Idle:     "infinity",
 					},
 					RetryPolicy: defaultRetryPolicy(),
-					Conditions: []v1.MatchCondition{{
-						Header: &v1.HeaderMatchCondition{
-							Name:  "K-Network-Hash",
-							Exact: "override",
-						},
-					}},
 					RequestHeadersPolicy: &v1.HeadersPolicy{
 						Set: []v1.HeaderValue{{
-							Name:  "Foo",
-							Value: "bar",
-						}, {
 							Name:  "K-Network-Hash",
-							Value: "fe69d18ca560b548fd9cd1fe9a417a4de806f573b52353a435e222a23a984127",
+							Value: "26cd28744ce6cfac6246208a399b55db730aebd63bf90f7e4bba9b028b718993",
 						}},
 					},
 					Services: []v1.Service{{
-						Name:     "htwo",
+						Name:     "goo",
+						Protocol: &protocol,
 						Port:     123,
-						Protocol: &h2Proto,
-						UpstreamValidation: &v1.UpstreamValidation{
-							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
-							SubjectName:   "data-plane.knative.dev",
-						},
-						Weight: 100,
-						RequestHeadersPolicy: &v1.HeadersPolicy{
-							Set: []v1.HeaderValue{{
-								Name:  "Baz",
-								Value: "blah",
-							}, {
-								Name:  "Bleep",
-								Value: "bloop",
-							}},
-						},
+						Weight:   100,
 					}},
 				}, {
+					Conditions: []v1.MatchCondition{{
+						Header: &v1.HeaderMatchCondition{
+							Name:  "b-header",
+							Exact: "b",
+						},
+					}, {
+						Header: &v1.HeaderMatchCondition{
+							Name:  "a-header",
+							Exact: "a",
+						},
+					}},
 					EnableWebsockets: true,
 					PermitInsecure:   true,
 					TimeoutPolicy: &v1.TimeoutPolicy{
@@ -2127,35 +2167,65 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					},
 					RetryPolicy: defaultRetryPolicy(),
 					RequestHeadersPolicy: &v1.HeadersPolicy{
-						Set: []v1.HeaderValue{{
-							Name:  "Foo",
-							Value: "bar",
-						}},
+						Set: []v1.HeaderValue{},
 					},
 					Services: []v1.Service{{
-						Name:     "htwo",
+						Name:     "goo",
+						Protocol: &protocol,
 						Port:     123,
-						Protocol: &h2Proto,
-						UpstreamValidation: &v1.UpstreamValidation{
-							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
-							SubjectName:   "data-plane.knative.dev",
-						},
-						Weight: 100,
-						RequestHeadersPolicy: &v1.HeadersPolicy{
-							Set: []v1.HeaderValue{{
-								Name:  "Baz",
-								Value: "blah",
-							}, {
-								Name:  "Bleep",
-								Value: "bloop",
-							}},
-						},
+						Weight:   100,
 					}},
 				}},
 			},
 		}},
-	}, {
-		name: "single external domain with internal encryption enabled and http01 challenge endpoints",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &config.Config{
+				Contour: &config.Contour{
+					VisibilityClasses: map[v1alpha1.IngressVisibility]string{
+						v1alpha1.IngressVisibilityClusterLocal: privateClass,
+						v1alpha1.IngressVisibilityExternalIP:   publicClass,
+					},
+					TimeoutPolicyResponse: "infinity",
+					TimeoutPolicyIdle:     "infinity",
+				},
+			}
+
+			if test.modifyConfig != nil {
+				test.modifyConfig(config)
+			}
+
+			tcs := &testConfigStore{config: config}
+			ctx := tcs.ToContext(context.Background())
+
+			got, err := MakeHTTPProxies(ctx, test.ing, serviceToProtocol)
+			if err != nil {
+				t.Fatal("MakeHTTPProxies() =", err)
+			}
+			if !cmp.Equal(test.want, got) {
+				t.Error("MakeHTTPProxies (-want, +got) =", cmp.Diff(test.want, got))
+			}
+		})
+	}
+}
+
+func TestMakeProxiesInternalEncryption(t *testing.T) {
+	tlsProto := InternalEncryptionProtocol
+	h2Proto := InternalEncryptionH2Protocol
+	serviceToProtocol := map[string]string{
+		"goo":  tlsProto,
+		"htwo": h2Proto,
+	}
+
+	tests := []struct {
+		name         string
+		ing          *v1alpha1.Ingress
+		want         []*v1.HTTPProxy
+		modifyConfig func(*config.Config)
+	}{{
+		name: "single external domain with internal encryption enabled",
 		ing: &v1alpha1.Ingress{
 			ObjectMeta: metav1.ObjectMeta{
 				Namespace: "foo",
@@ -2168,6 +2238,9 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					Visibility: v1alpha1.IngressVisibilityExternalIP,
 					HTTP: &v1alpha1.HTTPIngressRuleValue{
 						Paths: []v1alpha1.HTTPIngressPath{{
+							AppendHeaders: map[string]string{
+								"Foo": "bar",
+							},
 							Splits: []v1alpha1.IngressBackendSplit{{
 								IngressBackend: v1alpha1.IngressBackend{
 									ServiceName:      "goo",
@@ -2180,28 +2253,6 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 									"Bleep": "bloop",
 								},
 							}},
-						}, {
-							Path: "/.well-known/acme-challenge/some-challenge",
-							Splits: []v1alpha1.IngressBackendSplit{
-								{
-									IngressBackend: v1alpha1.IngressBackend{
-										ServiceName:      "acme-http-solver",
-										ServiceNamespace: "foo",
-										ServicePort:      intstr.FromInt(8089),
-									},
-									Percent: 100,
-								},
-							},
-							/*
-								Note for self: http01 challenges only come up for new domains. So if you delete hello and recreate it, a new challenge won't happen, and won't trigger the case where it tries to use tls to the challenge endpoint
-								   - path: /.well-known/acme-challenge/DzPfge0gXYvu417QIIQ9IAd9_YaG-rl_qby3HQlBbQA
-								     splits:
-								     - percent: 100
-								       serviceName: cm-acme-http-solver-rczvs
-								       serviceNamespace: default
-								       servicePort: 8089
-							*/
-
 						}},
 					},
 				}},
@@ -2240,18 +2291,21 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 						Idle:     "infinity",
 					},
 					RetryPolicy: defaultRetryPolicy(),
-					RequestHeadersPolicy: &v1.HeadersPolicy{
-						Set: []v1.HeaderValue{{
-							Name:  "K-Network-Hash",
-							Value: "62a84eab49a55afbf471afc85d08701d4beff2fc957b4d7614048320d0795597",
-						}},
-					},
 					Conditions: []v1.MatchCondition{{
 						Header: &v1.HeaderMatchCondition{
 							Name:  "K-Network-Hash",
 							Exact: "override",
 						},
 					}},
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{{
+							Name:  "Foo",
+							Value: "bar",
+						}, {
+							Name:  "K-Network-Hash",
+							Value: "6d6a4e524d201b156fae9b8d16e5dc534c6a2c18ca612061c9a5ac4b797affbb",
+						}},
+					},
 					Services: []v1.Service{{
 						Name:     "goo",
 						Port:     123,
@@ -2281,24 +2335,92 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 					RetryPolicy: defaultRetryPolicy(),
 					RequestHeadersPolicy: &v1.HeadersPolicy{
 						Set: []v1.HeaderValue{{
-							Name:  "K-Network-Hash",
-							Value: "62a84eab49a55afbf471afc85d08701d4beff2fc957b4d7614048320d0795597",
+							Name:  "Foo",
+							Value: "bar",
 						}},
 					},
-					Conditions: []v1.MatchCondition{{
-						Prefix: "/.well-known/acme-challenge/some-challenge",
-					}, {
-						Header: &v1.HeaderMatchCondition{
-							Name:  "K-Network-Hash",
-							Exact: "override",
-						},
-					}},
 					Services: []v1.Service{{
-						Name:   "acme-http-solver",
-						Port:   8089,
+						Name:     "goo",
+						Port:     123,
+						Protocol: &tlsProto,
+						UpstreamValidation: &v1.UpstreamValidation{
+							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
+							SubjectName:   "data-plane.knative.dev",
+						},
 						Weight: 100,
+						RequestHeadersPolicy: &v1.HeadersPolicy{
+							Set: []v1.HeaderValue{{
+								Name:  "Baz",
+								Value: "blah",
+							}, {
+								Name:  "Bleep",
+								Value: "bloop",
+							}},
+						},
 					}},
-				}, {
+				}},
+			},
+		}},
+	}, {
+		name: "single external domain with internal encryption enabled and http/2",
+		ing: &v1alpha1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "foo",
+				Name:      "bar",
+			},
+			Spec: v1alpha1.IngressSpec{
+				HTTPOption: v1alpha1.HTTPOptionEnabled,
+				Rules: []v1alpha1.IngressRule{{
+					Hosts:      []string{"example.com"},
+					Visibility: v1alpha1.IngressVisibilityExternalIP,
+					HTTP: &v1alpha1.HTTPIngressRuleValue{
+						Paths: []v1alpha1.HTTPIngressPath{{
+							AppendHeaders: map[string]string{
+								"Foo": "bar",
+							},
+							Splits: []v1alpha1.IngressBackendSplit{{
+								IngressBackend: v1alpha1.IngressBackend{
+									ServiceName:      "htwo",
+									ServiceNamespace: "foo",
+									ServicePort:      intstr.FromInt(123),
+								},
+								Percent: 100,
+								AppendHeaders: map[string]string{
+									"Baz":   "blah",
+									"Bleep": "bloop",
+								},
+							}},
+						}},
+					},
+				}},
+			},
+		},
+		want: []*v1.HTTPProxy{{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "foo",
+				Name:      "bar-" + publicClass + "-example.com",
+				Labels: map[string]string{
+					DomainHashKey: "0caaf24ab1a0c33440c06afe99df986365b0781f",
+					GenerationKey: "0",
+					ParentKey:     "bar",
+					ClassKey:      publicClass,
+				},
+				Annotations: map[string]string{
+					ClassKey: publicClass,
+				},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion:         "networking.internal.knative.dev/v1alpha1",
+					Kind:               "Ingress",
+					Name:               "bar",
+					Controller:         ptr.Bool(true),
+					BlockOwnerDeletion: ptr.Bool(true),
+				}},
+			},
+			Spec: v1.HTTPProxySpec{
+				VirtualHost: &v1.VirtualHost{
+					Fqdn: "example.com",
+				},
+				Routes: []v1.Route{{
 					EnableWebsockets: true,
 					PermitInsecure:   true,
 					TimeoutPolicy: &v1.TimeoutPolicy{
@@ -2306,13 +2428,25 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 						Idle:     "infinity",
 					},
 					RetryPolicy: defaultRetryPolicy(),
+					Conditions: []v1.MatchCondition{{
+						Header: &v1.HeaderMatchCondition{
+							Name:  "K-Network-Hash",
+							Exact: "override",
+						},
+					}},
 					RequestHeadersPolicy: &v1.HeadersPolicy{
-						Set: []v1.HeaderValue{},
+						Set: []v1.HeaderValue{{
+							Name:  "Foo",
+							Value: "bar",
+						}, {
+							Name:  "K-Network-Hash",
+							Value: "fe69d18ca560b548fd9cd1fe9a417a4de806f573b52353a435e222a23a984127",
+						}},
 					},
 					Services: []v1.Service{{
-						Name:     "goo",
+						Name:     "htwo",
 						Port:     123,
-						Protocol: &tlsProto,
+						Protocol: &h2Proto,
 						UpstreamValidation: &v1.UpstreamValidation{
 							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
 							SubjectName:   "data-plane.knative.dev",
@@ -2335,17 +2469,227 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 						Response: "infinity",
 						Idle:     "infinity",
 					},
-					Conditions: []v1.MatchCondition{{
-						Prefix: "/.well-known/acme-challenge/some-challenge",
-					}},
 					RetryPolicy: defaultRetryPolicy(),
 					RequestHeadersPolicy: &v1.HeadersPolicy{
-						Set: []v1.HeaderValue{},
+						Set: []v1.HeaderValue{{
+							Name:  "Foo",
+							Value: "bar",
+						}},
 					},
 					Services: []v1.Service{{
-						Name:   "acme-http-solver",
-						Port:   8089,
+						Name:     "htwo",
+						Port:     123,
+						Protocol: &h2Proto,
+						UpstreamValidation: &v1.UpstreamValidation{
+							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
+							SubjectName:   "data-plane.knative.dev",
+						},
 						Weight: 100,
+						RequestHeadersPolicy: &v1.HeadersPolicy{
+							Set: []v1.HeaderValue{{
+								Name:  "Baz",
+								Value: "blah",
+							}, {
+								Name:  "Bleep",
+								Value: "bloop",
+							}},
+						},
+					}},
+				}},
+			},
+		}},
+	}, {
+		name: "single external domain with internal encryption enabled and http01 challenge endpoints",
+		ing: &v1alpha1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "foo",
+				Name:      "bar",
+			},
+			Spec: v1alpha1.IngressSpec{
+				HTTPOption: v1alpha1.HTTPOptionEnabled,
+				Rules: []v1alpha1.IngressRule{{
+					Hosts:      []string{"example.com"},
+					Visibility: v1alpha1.IngressVisibilityExternalIP,
+					HTTP: &v1alpha1.HTTPIngressRuleValue{
+						Paths: []v1alpha1.HTTPIngressPath{{
+							Splits: []v1alpha1.IngressBackendSplit{{
+								IngressBackend: v1alpha1.IngressBackend{
+									ServiceName:      "goo",
+									ServiceNamespace: "foo",
+									ServicePort:      intstr.FromInt(123),
+								},
+								Percent: 100,
+								AppendHeaders: map[string]string{
+									"Baz":   "blah",
+									"Bleep": "bloop",
+								},
+							}},
+						}, {
+							Path: "/.well-known/acme-challenge/some-challenge",
+							Splits: []v1alpha1.IngressBackendSplit{
+								{
+									IngressBackend: v1alpha1.IngressBackend{
+										ServiceName:      "acme-http-solver",
+										ServiceNamespace: "foo",
+										ServicePort:      intstr.FromInt(8089),
+									},
+									Percent: 100,
+								},
+							},
+							/*
+								Note for self: http01 challenges only come up for new domains. So if you delete hello and recreate it, a new challenge won't happen, and won't trigger the case where it tries to use tls to the challenge endpoint
+								   - path: /.well-known/acme-challenge/DzPfge0gXYvu417QIIQ9IAd9_YaG-rl_qby3HQlBbQA
+								     splits:
+								     - percent: 100
+								       serviceName: cm-acme-http-solver-rczvs
+								       serviceNamespace: default
+								       servicePort: 8089
+							*/
+
+						}},
+					},
+				}},
+			},
+		},
+		want: []*v1.HTTPProxy{{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "foo",
+				Name:      "bar-" + publicClass + "-example.com",
+				Labels: map[string]string{
+					DomainHashKey: "0caaf24ab1a0c33440c06afe99df986365b0781f",
+					GenerationKey: "0",
+					ParentKey:     "bar",
+					ClassKey:      publicClass,
+				},
+				Annotations: map[string]string{
+					ClassKey: publicClass,
+				},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion:         "networking.internal.knative.dev/v1alpha1",
+					Kind:               "Ingress",
+					Name:               "bar",
+					Controller:         ptr.Bool(true),
+					BlockOwnerDeletion: ptr.Bool(true),
+				}},
+			},
+			Spec: v1.HTTPProxySpec{
+				VirtualHost: &v1.VirtualHost{
+					Fqdn: "example.com",
+				},
+				Routes: []v1.Route{{
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{{
+							Name:  "K-Network-Hash",
+							Value: "62a84eab49a55afbf471afc85d08701d4beff2fc957b4d7614048320d0795597",
+						}},
+					},
+					Conditions: []v1.MatchCondition{{
+						Prefix: "/.well-known/acme-challenge/some-challenge",
+					}, {
+						Header: &v1.HeaderMatchCondition{
+							Name:  "K-Network-Hash",
+							Exact: "override",
+						},
+					}},
+					Services: []v1.Service{{
+						Name:   "acme-http-solver",
+						Port:   8089,
+						Weight: 100,
+					}},
+				}, {
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					Conditions: []v1.MatchCondition{{
+						Prefix: "/.well-known/acme-challenge/some-challenge",
+					}},
+					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{},
+					},
+					Services: []v1.Service{{
+						Name:   "acme-http-solver",
+						Port:   8089,
+						Weight: 100,
+					}},
+				}, {
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{{
+							Name:  "K-Network-Hash",
+							Value: "62a84eab49a55afbf471afc85d08701d4beff2fc957b4d7614048320d0795597",
+						}},
+					},
+					Conditions: []v1.MatchCondition{{
+						Header: &v1.HeaderMatchCondition{
+							Name:  "K-Network-Hash",
+							Exact: "override",
+						},
+					}},
+					Services: []v1.Service{{
+						Name:     "goo",
+						Port:     123,
+						Protocol: &tlsProto,
+						UpstreamValidation: &v1.UpstreamValidation{
+							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
+							SubjectName:   "data-plane.knative.dev",
+						},
+						Weight: 100,
+						RequestHeadersPolicy: &v1.HeadersPolicy{
+							Set: []v1.HeaderValue{{
+								Name:  "Baz",
+								Value: "blah",
+							}, {
+								Name:  "Bleep",
+								Value: "bloop",
+							}},
+						},
+					}},
+				}, {
+					EnableWebsockets: true,
+					PermitInsecure:   true,
+					TimeoutPolicy: &v1.TimeoutPolicy{
+						Response: "infinity",
+						Idle:     "infinity",
+					},
+					RetryPolicy: defaultRetryPolicy(),
+					RequestHeadersPolicy: &v1.HeadersPolicy{
+						Set: []v1.HeaderValue{},
+					},
+					Services: []v1.Service{{
+						Name:     "goo",
+						Port:     123,
+						Protocol: &tlsProto,
+						UpstreamValidation: &v1.UpstreamValidation{
+							CACertificate: fmt.Sprintf("%s/knative-serving-certs", system.Namespace()),
+							SubjectName:   "data-plane.knative.dev",
+						},
+						Weight: 100,
+						RequestHeadersPolicy: &v1.HeadersPolicy{
+							Set: []v1.HeaderValue{{
+								Name:  "Baz",
+								Value: "blah",
+							}, {
+								Name:  "Bleep",
+								Value: "bloop",
+							}},
+						},
 					}},
 				}},
 			},
@@ -2376,7 +2720,10 @@ func TestMakeProxiesInternalEncryption(t *testing.T) {
 			tcs := &testConfigStore{config: config}
 			ctx := tcs.ToContext(context.Background())
 
-			got := MakeHTTPProxies(ctx, test.ing, serviceToProtocol)
+			got, err := MakeHTTPProxies(ctx, test.ing, serviceToProtocol)
+			if err != nil {
+				t.Fatal("MakeHTTPProxies() =", err)
+			}
 			if !cmp.Equal(test.want, got) {
 				t.Error("MakeHTTPProxies (-want, +got) =", cmp.Diff(test.want, got))
 			}
@@ -2447,12 +2794,1269 @@ func TestServiceNames(t *testing.T) {
 	}
 }
 
-type testConfigStore struct {
-	config *config.Config
+func TestMakeHeaderMatchCondition(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		annotations map[string]string
+		exact       string
+		want        string
+		wantErr     bool
+	}{{
+		name:  "no annotation",
+		exact: "bar",
+		want:  `{"name":"X-Foo","exact":"bar"}`,
+	}, {
+		name:   "network header",
+		header: "K-Network-Hash",
+		exact:  "bar",
+		want:   `{"name":"K-Network-Hash","exact":"bar"}`,
+	}, {
+		name:   "network header inverted",
+		header: "K-Network-Hash",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "k-network-hash": "true",
+		},
+		exact:   "bar",
+		wantErr: true,
+	}, {
+		name:   "other network header inverted",
+		header: "k-network-probe",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "k-network-probe": "false",
+		},
+		exact:   "bar",
+		wantErr: true,
+	}, {
+		name: "empty exact value",
+		// Serialized without "exact", Contour would drop the condition.
+		wantErr: true,
+	}, {
+		name: "inverted",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "x-foo": "true",
+		},
+		exact: "bar",
+		want:  `{"name":"X-Foo","notexact":"bar"}`,
+	}, {
+		name: "not inverted",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "x-foo": "false",
+		},
+		exact: "bar",
+		want:  `{"name":"X-Foo","exact":"bar"}`,
+	}, {
+		name: "other header inverted",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "x-bar": "true",
+		},
+		exact: "bar",
+		want:  `{"name":"X-Foo","exact":"bar"}`,
+	}, {
+		name: "invalid",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "x-foo": "yes please",
+		},
+		exact:   "bar",
+		wantErr: true,
+	}, {
+		name: "inverted without value",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "x-foo": "true",
+		},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := test.header
+			if header == "" {
+				header = "X-Foo"
+			}
+			ing := makeTestIngress(test.annotations)
+			cond, err := makeHeaderMatchCondition(ing, header, v1alpha1.HeaderMatch{Exact: test.exact})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("makeHeaderMatchCondition() = %v, wantErr = %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			got, err := json.Marshal(cond)
+			if err != nil {
+				t.Fatal("json.Marshal() =", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("makeHeaderMatchCondition() = %s, wanted %s", got, test.want)
+			}
+		})
+	}
 }
 
-func (t *testConfigStore) ToContext(ctx context.Context) context.Context {
-	return config.ToContext(ctx, t.config)
+func TestUpstreamProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		protocol *string
+		want     *string
+		wantErr  bool
+	}{{
+		name:     "no annotation",
+		protocol: ptr.String("h2c"),
+		want:     ptr.String("h2c"),
+	}, {
+		name:     "auto",
+		version:  "AUTO",
+		protocol: ptr.String("h2c"),
+		want:     ptr.String("h2c"),
+	}, {
+		name:    "http2",
+		version: "HTTP2",
+		want:    ptr.String("h2c"),
+	}, {
+		name:     "http2 encrypted",
+		version:  "HTTP2",
+		protocol: ptr.String(InternalEncryptionProtocol),
+		want:     ptr.String(InternalEncryptionH2Protocol),
+	}, {
+		name:     "http1",
+		version:  "HTTP1",
+		protocol: ptr.String("h2c"),
+	}, {
+		name:     "http1 encrypted",
+		version:  "HTTP1",
+		protocol: ptr.String(InternalEncryptionH2Protocol),
+		want:     ptr.String(InternalEncryptionProtocol),
+	}, {
+		name:    "invalid",
+		version: "HTTP3",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var annotations map[string]string
+			if test.version != "" {
+				annotations = map[string]string{
+					UpstreamHTTPVersionKeyPrefix + "goo": test.version,
+					UpstreamHTTPVersionKeyPrefix + "doo": "bogus",
+				}
+			}
+			got, err := upstreamProtocol(makeTestIngress(annotations), "goo", test.protocol)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("upstreamProtocol() = %v, wantErr = %v", err, test.wantErr)
+			}
+			if !cmp.Equal(test.want, got) {
+				t.Error("upstreamProtocol() (-want, +got) =", cmp.Diff(test.want, got))
+			}
+		})
+	}
 }
 
-var _ reconciler.ConfigStore = (*testConfigStore)(nil)
+func TestValidateFQDN(t *testing.T) {
+	tests := []struct {
+		name    string
+		fqdn    string
+		wantErr bool
+	}{{
+		name: "simple",
+		fqdn: "example.com",
+	}, {
+		name: "cluster local",
+		fqdn: "foo.bar.svc.cluster.local",
+	}, {
+		name: "single label",
+		fqdn: "localhost",
+	}, {
+		name: "hyphens and digits",
+		fqdn: "my-app-2.example.com",
+	}, {
+		name: "wildcard",
+		fqdn: "*.example.com",
+	}, {
+		name:    "wildcard not first",
+		fqdn:    "foo.*.example.com",
+		wantErr: true,
+	}, {
+		name:    "partial wildcard",
+		fqdn:    "foo*.example.com",
+		wantErr: true,
+	}, {
+		name:    "empty",
+		fqdn:    "",
+		wantErr: true,
+	}, {
+		name:    "empty label",
+		fqdn:    "foo..example.com",
+		wantErr: true,
+	}, {
+		name:    "trailing dot",
+		fqdn:    "example.com.",
+		wantErr: true,
+	}, {
+		name:    "leading hyphen",
+		fqdn:    "-foo.example.com",
+		wantErr: true,
+	}, {
+		name:    "trailing hyphen",
+		fqdn:    "foo-.example.com",
+		wantErr: true,
+	}, {
+		name:    "underscore",
+		fqdn:    "foo_bar.example.com",
+		wantErr: true,
+	}, {
+		name:    "uppercase",
+		fqdn:    "Foo.example.com",
+		wantErr: true,
+	}, {
+		name: "63 character label",
+		fqdn: strings.Repeat("a", 63) + ".com",
+	}, {
+		name:    "64 character label",
+		fqdn:    strings.Repeat("a", 64) + ".com",
+		wantErr: true,
+	}, {
+		name: "253 characters",
+		fqdn: strings.Repeat(strings.Repeat("a", 62)+".", 4) + "a",
+	}, {
+		name:    "254 characters",
+		fqdn:    strings.Repeat(strings.Repeat("a", 62)+".", 4) + "aa",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateFQDN(test.fqdn); (err != nil) != test.wantErr {
+				t.Errorf("validateFQDN() = %v, wantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyUpstreamTransport(t *testing.T) {
+	validation := &v1.UpstreamValidation{CACertificate: "ns/ca", SubjectName: "example"}
+
+	tests := []struct {
+		name      string
+		transport string
+		svc       v1.Service
+		want      v1.Service
+		wantErr   bool
+	}{{
+		name: "no annotation",
+		svc:  v1.Service{Name: "goo", Protocol: ptr.String("h2c")},
+		want: v1.Service{Name: "goo", Protocol: ptr.String("h2c")},
+	}, {
+		name:      "tls over http1",
+		transport: "tls",
+		svc:       v1.Service{Name: "goo"},
+		want:      v1.Service{Name: "goo", Protocol: ptr.String(InternalEncryptionProtocol)},
+	}, {
+		name:      "tls over h2c",
+		transport: "tls",
+		svc:       v1.Service{Name: "goo", Protocol: ptr.String("h2c")},
+		want:      v1.Service{Name: "goo", Protocol: ptr.String(InternalEncryptionH2Protocol)},
+	}, {
+		name:      "raw buffer over tls",
+		transport: "raw_buffer",
+		svc:       v1.Service{Name: "goo", Protocol: ptr.String(InternalEncryptionProtocol), UpstreamValidation: validation},
+		want:      v1.Service{Name: "goo"},
+	}, {
+		name:      "raw buffer over h2",
+		transport: "raw_buffer",
+		svc:       v1.Service{Name: "goo", Protocol: ptr.String(InternalEncryptionH2Protocol), UpstreamValidation: validation},
+		want:      v1.Service{Name: "goo", Protocol: ptr.String("h2c")},
+	}, {
+		name:      "invalid",
+		transport: "quic",
+		svc:       v1.Service{Name: "goo"},
+		wantErr:   true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var annotations map[string]string
+			if test.transport != "" {
+				annotations = map[string]string{
+					UpstreamTransportSocketKeyPrefix + "goo": test.transport,
+				}
+			}
+			svc := test.svc
+			if err := applyUpstreamTransport(makeTestIngress(annotations), &svc); (err != nil) != test.wantErr {
+				t.Fatalf("applyUpstreamTransport() = %v, wantErr = %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if !cmp.Equal(test.want, svc) {
+				t.Error("applyUpstreamTransport() (-want, +got) =", cmp.Diff(test.want, svc))
+			}
+		})
+	}
+}
+
+func TestPathKey(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{{
+		name: "root",
+		path: "",
+	}, {
+		name: "simple",
+		path: "/public",
+	}, {
+		name: "long",
+		path: "/" + strings.Repeat("a", 252),
+	}, {
+		// The base64url encoding of this path ends with "_".
+		name: "encoding ends with underscore",
+		path: "/a?",
+	}, {
+		// The base64url encoding of this path ends with "-".
+		name: "encoding ends with hyphen",
+		path: "/api/~",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := pathKey(test.path)
+			if len(got) != 16 {
+				t.Errorf("pathKey() = %q, wanted 16 characters", got)
+			}
+			for _, name := range []string{
+				AuthDisabledKeyPrefix + got,
+				AuthContextKeyPrefix + strings.Repeat("k", 33) + "-" + got,
+			} {
+				if errs := validation.IsQualifiedName(name); len(errs) != 0 {
+					t.Errorf("pathKey() = %q, which makes an invalid annotation name: %v", got, errs)
+				}
+			}
+			if again := pathKey(test.path); again != got {
+				t.Errorf("pathKey() = %q, then %q; wanted a stable key", got, again)
+			}
+			if other := pathKey(test.path + "/"); other == got {
+				t.Errorf("pathKey() = %q for distinct paths, wanted distinct keys", got)
+			}
+		})
+	}
+}
+
+func TestSafeProxyName(t *testing.T) {
+	// kmeta.ChildName keeps names of up to 63 characters as they are.
+	const prefix = "name-public-"
+	hostFor := func(nameLength int) string {
+		return strings.Repeat("a", nameLength-len(prefix))
+	}
+
+	tests := []struct {
+		name          string
+		host          string
+		wantTruncated bool
+	}{{
+		name: "well under the limit",
+		host: "example.com",
+	}, {
+		name: "62 characters",
+		host: hostFor(62),
+	}, {
+		name: "63 characters",
+		host: hostFor(63),
+	}, {
+		name:          "64 characters",
+		host:          hostFor(64),
+		wantTruncated: true,
+	}, {
+		name:          "well over the limit",
+		host:          hostFor(253),
+		wantTruncated: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := safeProxyName("name", "public", test.host)
+			if untruncated := prefix + test.host; (got != untruncated) != test.wantTruncated {
+				t.Errorf("safeProxyName() = %q, wantTruncated = %v", got, test.wantTruncated)
+			}
+			if len(got) > 63 {
+				t.Errorf("safeProxyName() = %q, longer than 63 characters", got)
+			}
+			if errs := validation.IsDNS1123Subdomain(got); len(errs) != 0 {
+				t.Errorf("safeProxyName() = %q, which is not a valid name: %v", got, errs)
+			}
+			if again := safeProxyName("name", "public", test.host); again != got {
+				t.Errorf("safeProxyName() = %q, then %q; wanted a stable name", got, again)
+			}
+			if other := safeProxyName("name", "public", "b"+test.host[1:]); other == got {
+				t.Errorf("safeProxyName() = %q for distinct hosts, wanted distinct names", got)
+			}
+		})
+	}
+}
+
+func TestMakeProxiesAnnotations(t *testing.T) {
+	split := func(service string, port, percent int) v1alpha1.IngressBackendSplit {
+		return v1alpha1.IngressBackendSplit{
+			IngressBackend: v1alpha1.IngressBackend{
+				ServiceName: service,
+				ServicePort: intstr.FromInt(port),
+			},
+			Percent: percent,
+		}
+	}
+	path := func(p string, splits ...v1alpha1.IngressBackendSplit) v1alpha1.HTTPIngressPath {
+		return v1alpha1.HTTPIngressPath{
+			Path:   p,
+			Splits: splits,
+		}
+	}
+	authPaths := []v1alpha1.HTTPIngressPath{
+		path("/public", split("goo", 123, 100)),
+		path("/private", split("goo", 123, 100)),
+		path(HTTPChallengePath+"/some-challenge", split("acme-http-solver", 8089, 100)),
+	}
+	withHeader := []v1alpha1.HTTPIngressPath{{
+		Headers: map[string]v1alpha1.HeaderMatch{
+			"x-foo": {Exact: "bar"},
+		},
+		Splits: []v1alpha1.IngressBackendSplit{split("goo", 123, 100)},
+	}}
+	gooAndDoo := []v1alpha1.HTTPIngressPath{
+		path("", split("goo", 123, 50), split("doo", 124, 50)),
+	}
+
+	extensionService := func(name string) *v1.AuthorizationServer {
+		return &v1.AuthorizationServer{
+			ExtensionServiceRef: v1.ExtensionServiceReference{
+				APIVersion: DefaultExtensionServiceAPIVersion,
+				Name:       name,
+			},
+		}
+	}
+	withAuthorization := func(auth *v1.AuthorizationServer) func(*v1.HTTPProxy) {
+		return func(proxy *v1.HTTPProxy) {
+			proxy.Spec.VirtualHost.Authorization = auth
+		}
+	}
+	// forEachRoute applies f to every route, including the probe routes.
+	forEachRoute := func(f func(*v1.Route)) func(*v1.HTTPProxy) {
+		return func(proxy *v1.HTTPProxy) {
+			for i := range proxy.Spec.Routes {
+				f(&proxy.Spec.Routes[i])
+			}
+		}
+	}
+	// withAuthPolicies sets the authorization of the "es" extension service
+	// and the given route policies, keyed by route prefix.
+	withAuthPolicies := func(policies map[string]*v1.AuthorizationPolicy) func(*v1.HTTPProxy) {
+		return func(proxy *v1.HTTPProxy) {
+			withAuthorization(extensionService("es"))(proxy)
+			forEachRoute(func(route *v1.Route) {
+				route.AuthPolicy = policies[routePrefix(*route)]
+			})(proxy)
+		}
+	}
+	withResponseTimeout := func(timeout string) func(*v1.Route) {
+		return func(route *v1.Route) {
+			route.TimeoutPolicy.Response = timeout
+		}
+	}
+	withRetryPolicy := func(modify func(*v1.RetryPolicy)) func(*v1.Route) {
+		return func(route *v1.Route) {
+			modify(route.RetryPolicy)
+		}
+	}
+	withLoadBalancerPolicy := func(policy *v1.LoadBalancerPolicy) func(*v1.HTTPProxy) {
+		return forEachRoute(func(route *v1.Route) {
+			route.LoadBalancerPolicy = policy
+		})
+	}
+	disabled := &v1.AuthorizationPolicy{Disabled: true}
+	routeResponseTimeout := func(cfg *config.Config) {
+		cfg.Contour.TimeoutPolicyResponse = "10s"
+	}
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		paths        []v1alpha1.HTTPIngressPath
+		modifyConfig func(*config.Config)
+		// want applies the expected changes to the proxies made for the same
+		// Ingress without annotations, and with the default configuration.
+		want    func(*v1.HTTPProxy)
+		wantErr bool
+	}{{
+		name: "extension service",
+		annotations: map[string]string{
+			ExtensionServiceKey: "es",
+		},
+		want: withAuthorization(extensionService("es")),
+	}, {
+		name: "auth extensions uses the first entry",
+		annotations: map[string]string{
+			AuthExtensionsKey:            "primary-svc, fallback-svc",
+			ExtensionServiceNamespaceKey: "es-ns",
+		},
+		want: withAuthorization(&v1.AuthorizationServer{
+			ExtensionServiceRef: v1.ExtensionServiceReference{
+				APIVersion: DefaultExtensionServiceAPIVersion,
+				Name:       "primary-svc",
+				Namespace:  "es-ns",
+			},
+		}),
+	}, {
+		name: "extension service takes precedence over auth extensions",
+		annotations: map[string]string{
+			ExtensionServiceKey: "es",
+			AuthExtensionsKey:   "primary-svc,fallback-svc",
+		},
+		want: withAuthorization(extensionService("es")),
+	}, {
+		name: "extension service api version",
+		annotations: map[string]string{
+			ExtensionServiceKey:           "es",
+			ExtensionServiceAPIVersionKey: "projectcontour.io/v1",
+		},
+		want: withAuthorization(&v1.AuthorizationServer{
+			ExtensionServiceRef: v1.ExtensionServiceReference{
+				APIVersion: "projectcontour.io/v1",
+				Name:       "es",
+			},
+		}),
+	}, {
+		name: "empty auth extensions",
+		annotations: map[string]string{
+			AuthExtensionsKey: " , ",
+		},
+	}, {
+		name: "with request body",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthWithRequestBodyKey: "true",
+		},
+		want: func(proxy *v1.HTTPProxy) {
+			auth := extensionService("es")
+			auth.WithRequestBody = &v1.AuthorizationServerBufferSettings{}
+			withAuthorization(auth)(proxy)
+		},
+	}, {
+		name: "with request body and max bytes",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthWithRequestBodyKey: "true",
+			AuthMaxRequestBodyKey:  "4096",
+		},
+		want: func(proxy *v1.HTTPProxy) {
+			auth := extensionService("es")
+			auth.WithRequestBody = &v1.AuthorizationServerBufferSettings{
+				MaxRequestBytes: 4096,
+			}
+			withAuthorization(auth)(proxy)
+		},
+	}, {
+		name: "without request body ignores max bytes",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthWithRequestBodyKey: "false",
+			AuthMaxRequestBodyKey:  "4096",
+		},
+		want: withAuthorization(extensionService("es")),
+	}, {
+		name: "invalid with request body",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthWithRequestBodyKey: "yes please",
+		},
+		wantErr: true,
+	}, {
+		name: "zero max bytes",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthWithRequestBodyKey: "true",
+			AuthMaxRequestBodyKey:  "0",
+		},
+		wantErr: true,
+	}, {
+		name: "negative max bytes",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthWithRequestBodyKey: "true",
+			AuthMaxRequestBodyKey:  "-1",
+		},
+		wantErr: true,
+	}, {
+		name: "auth response timeout",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthResponseTimeoutKey: "5s",
+		},
+		want: func(proxy *v1.HTTPProxy) {
+			auth := extensionService("es")
+			auth.ResponseTimeout = "5s"
+			withAuthorization(auth)(proxy)
+		},
+	}, {
+		name: "auth response timeout less than route response timeout",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthResponseTimeoutKey: "5s",
+		},
+		modifyConfig: routeResponseTimeout,
+		want: func(proxy *v1.HTTPProxy) {
+			auth := extensionService("es")
+			auth.ResponseTimeout = "5s"
+			withAuthorization(auth)(proxy)
+			forEachRoute(withResponseTimeout("10s"))(proxy)
+		},
+	}, {
+		name: "auth response timeout exceeds route response timeout",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthResponseTimeoutKey: "10s",
+		},
+		modifyConfig: routeResponseTimeout,
+		wantErr:      true,
+	}, {
+		name: "negative auth response timeout",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthResponseTimeoutKey: "-5s",
+		},
+		wantErr: true,
+	}, {
+		name: "invalid auth response timeout",
+		annotations: map[string]string{
+			ExtensionServiceKey:    "es",
+			AuthResponseTimeoutKey: "soon",
+		},
+		wantErr: true,
+	}, {
+		name: "auth disabled without authorization",
+		annotations: map[string]string{
+			AuthDisabledKeyPrefix + pathKey("/public"): "true",
+		},
+		paths: authPaths,
+	}, {
+		name: "authorization only disabled for acme challenges",
+		annotations: map[string]string{
+			ExtensionServiceKey: "es",
+		},
+		paths: authPaths,
+		want: withAuthPolicies(map[string]*v1.AuthorizationPolicy{
+			HTTPChallengePath + "/some-challenge": disabled,
+		}),
+	}, {
+		name: "authorization disabled for public path",
+		annotations: map[string]string{
+			ExtensionServiceKey:                         "es",
+			AuthDisabledKeyPrefix + pathKey("/public"):  "true",
+			AuthDisabledKeyPrefix + pathKey("/private"): "false",
+		},
+		paths: authPaths,
+		want: withAuthPolicies(map[string]*v1.AuthorizationPolicy{
+			"/public":                             disabled,
+			HTTPChallengePath + "/some-challenge": disabled,
+		}),
+	}, {
+		name: "authorization context",
+		annotations: map[string]string{
+			ExtensionServiceKey: "es",
+			AuthContextKeyPrefix + "tier-" + pathKey("/public"):   "free",
+			AuthContextKeyPrefix + "region-" + pathKey("/public"): "us",
+			AuthContextKeyPrefix + "tier-" + pathKey("/private"):  "paid",
+		},
+		paths: authPaths,
+		want: withAuthPolicies(map[string]*v1.AuthorizationPolicy{
+			"/public": {
+				Context: map[string]string{
+					"region": "us",
+					"tier":   "free",
+				},
+			},
+			"/private": {
+				Context: map[string]string{
+					"tier": "paid",
+				},
+			},
+			HTTPChallengePath + "/some-challenge": disabled,
+		}),
+	}, {
+		name: "authorization context on disabled path",
+		annotations: map[string]string{
+			ExtensionServiceKey:                                 "es",
+			AuthDisabledKeyPrefix + pathKey("/public"):          "true",
+			AuthContextKeyPrefix + "tier-" + pathKey("/public"): "free",
+			AuthContextKeyPrefix + "tier-" + pathKey("/other"):  "ignored",
+		},
+		paths: authPaths,
+		want: withAuthPolicies(map[string]*v1.AuthorizationPolicy{
+			"/public": {
+				Disabled: true,
+				Context: map[string]string{
+					"tier": "free",
+				},
+			},
+			HTTPChallengePath + "/some-challenge": disabled,
+		}),
+	}, {
+		name: "invalid auth disabled",
+		annotations: map[string]string{
+			ExtensionServiceKey:                        "es",
+			AuthDisabledKeyPrefix + pathKey("/public"): "sure",
+		},
+		paths:   authPaths,
+		wantErr: true,
+	}, {
+		name: "inverted header match",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "x-foo": "true",
+		},
+		paths: withHeader,
+		want: forEachRoute(func(route *v1.Route) {
+			for _, cond := range route.Conditions {
+				if cond.Header != nil && cond.Header.Name == "x-foo" {
+					cond.Header.NotExact, cond.Header.Exact = cond.Header.Exact, ""
+				}
+			}
+		}),
+	}, {
+		name: "invalid inverted header match",
+		annotations: map[string]string{
+			InvertHeaderMatchKeyPrefix + "x-foo": "yes please",
+		},
+		paths:   withHeader,
+		wantErr: true,
+	}, {
+		name: "h2c with streaming",
+		annotations: map[string]string{
+			GRPCStreamingKey: "true",
+		},
+		want: forEachRoute(withRetryPolicy(func(retry *v1.RetryPolicy) {
+			retry.RetryOn = []v1.RetryOn{
+				"connect-failure",
+				"refused-stream",
+				"retriable-status-codes",
+				"reset",
+			}
+		})),
+	}, {
+		name: "h2c with streaming disabled",
+		annotations: map[string]string{
+			GRPCStreamingKey: "false",
+		},
+	}, {
+		name: "http1 with streaming",
+		annotations: map[string]string{
+			GRPCStreamingKey: "true",
+		},
+		paths: []v1alpha1.HTTPIngressPath{path("", split("doo", 124, 100))},
+	}, {
+		name: "invalid streaming",
+		annotations: map[string]string{
+			GRPCStreamingKey: "maybe",
+		},
+		wantErr: true,
+	}, {
+		name:  "fractional weights",
+		paths: []v1alpha1.HTTPIngressPath{path("", split("goo", 123, 12), split("doo", 124, 88))},
+		modifyConfig: func(cfg *config.Config) {
+			cfg.Contour.UseFractionalWeights = true
+		},
+		want: forEachRoute(func(route *v1.Route) {
+			route.Services[0].Weight = 12000
+			route.Services[1].Weight = 88000
+		}),
+	}, {
+		name: "remove response headers per service",
+		annotations: map[string]string{
+			RemoveResponseHeaderKeyPrefix + "goo-x-powered-by": "true",
+			RemoveResponseHeaderKeyPrefix + "goo-Server":       "true",
+			RemoveResponseHeaderKeyPrefix + "goo-server":       "true",
+			RemoveResponseHeaderKeyPrefix + "goo-via":          "false",
+			RemoveResponseHeaderKeyPrefix + "goo-bar-server":   "true",
+			RemoveResponseHeaderKeyPrefix + "unknown-server":   "true",
+		},
+		paths: []v1alpha1.HTTPIngressPath{path("", split("goo", 123, 50), split("goo-bar", 123, 50))},
+		want: forEachRoute(func(route *v1.Route) {
+			route.Services[0].ResponseHeadersPolicy = &v1.HeadersPolicy{Remove: []string{"server", "x-powered-by"}}
+			route.Services[1].ResponseHeadersPolicy = &v1.HeadersPolicy{Remove: []string{"server"}}
+		}),
+	}, {
+		name: "invalid remove response header",
+		annotations: map[string]string{
+			RemoveResponseHeaderKeyPrefix + "goo-server": "yes",
+		},
+		wantErr: true,
+	}, {
+		name: "per try timeout",
+		annotations: map[string]string{
+			RetryPerTryTimeoutKey: "5s",
+		},
+		want: forEachRoute(withRetryPolicy(func(retry *v1.RetryPolicy) {
+			retry.PerTryTimeout = "5s"
+		})),
+	}, {
+		name: "per try timeout less than route response timeout",
+		annotations: map[string]string{
+			RetryPerTryTimeoutKey: "5s",
+		},
+		modifyConfig: routeResponseTimeout,
+		want: forEachRoute(func(route *v1.Route) {
+			withResponseTimeout("10s")(route)
+			route.RetryPolicy.PerTryTimeout = "5s"
+		}),
+	}, {
+		name: "per try timeout exceeds route response timeout",
+		annotations: map[string]string{
+			RetryPerTryTimeoutKey: "10s",
+		},
+		modifyConfig: routeResponseTimeout,
+		wantErr:      true,
+	}, {
+		name: "negative per try timeout",
+		annotations: map[string]string{
+			RetryPerTryTimeoutKey: "-5s",
+		},
+		wantErr: true,
+	}, {
+		name: "invalid per try timeout",
+		annotations: map[string]string{
+			RetryPerTryTimeoutKey: "soon",
+		},
+		wantErr: true,
+	}, {
+		name: "retriable status codes",
+		annotations: map[string]string{
+			RetryOnStatusKey: "503,504",
+		},
+		want: forEachRoute(withRetryPolicy(func(retry *v1.RetryPolicy) {
+			retry.RetriableStatusCodes = []uint32{503, 504}
+		})),
+	}, {
+		name: "retriable status codes sorted and deduplicated",
+		annotations: map[string]string{
+			RetryOnStatusKey: "504, 503,504",
+		},
+		want: forEachRoute(withRetryPolicy(func(retry *v1.RetryPolicy) {
+			retry.RetriableStatusCodes = []uint32{503, 504}
+		})),
+	}, {
+		name: "retriable status code out of range",
+		annotations: map[string]string{
+			RetryOnStatusKey: "503,600",
+		},
+		wantErr: true,
+	}, {
+		name: "invalid retriable status code",
+		annotations: map[string]string{
+			RetryOnStatusKey: "503,5xx",
+		},
+		wantErr: true,
+	}, {
+		name: "empty retriable status codes",
+		annotations: map[string]string{
+			RetryOnStatusKey: "",
+		},
+		wantErr: true,
+	}, {
+		name: "gRPC status codes",
+		annotations: map[string]string{
+			GRPCRetryOnKey: "UNAVAILABLE,DEADLINE_EXCEEDED",
+		},
+		want: forEachRoute(withRetryPolicy(func(retry *v1.RetryPolicy) {
+			retry.RetryOn = append(retry.RetryOn, "unavailable", "deadline-exceeded")
+		})),
+	}, {
+		name: "gRPC status codes already retried by default",
+		annotations: map[string]string{
+			GRPCRetryOnKey: "CANCELLED, RESOURCE_EXHAUSTED,INTERNAL,INTERNAL",
+		},
+		want: forEachRoute(withRetryPolicy(func(retry *v1.RetryPolicy) {
+			retry.RetryOn = append(retry.RetryOn, "internal")
+		})),
+	}, {
+		name: "gRPC status code that cannot be retried",
+		annotations: map[string]string{
+			GRPCRetryOnKey: "UNAVAILABLE,NOT_FOUND",
+		},
+		wantErr: true,
+	}, {
+		name: "unknown gRPC status code",
+		annotations: map[string]string{
+			GRPCRetryOnKey: "Unavailable",
+		},
+		wantErr: true,
+	}, {
+		name: "load balancer strategy only",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey: "WeightedLeastRequest",
+		},
+		paths: gooAndDoo,
+		want:  withLoadBalancerPolicy(&v1.LoadBalancerPolicy{Strategy: "WeightedLeastRequest"}),
+	}, {
+		name: "unknown load balancer strategy",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey: "LeastConnections",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "header hash",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:           "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo": "X-Session-ID",
+		},
+		paths: gooAndDoo,
+		want: withLoadBalancerPolicy(&v1.LoadBalancerPolicy{
+			Strategy: "RequestHash",
+			RequestHashPolicies: []v1.RequestHashPolicy{{
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: "X-Session-ID"},
+			}},
+		}),
+	}, {
+		name: "header hash per service in split order",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:           "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo": "X-Session-ID",
+			HashPolicyHeaderKeyPrefix + "doo": "X-User-ID",
+		},
+		paths: gooAndDoo,
+		want: withLoadBalancerPolicy(&v1.LoadBalancerPolicy{
+			Strategy: "RequestHash",
+			RequestHashPolicies: []v1.RequestHashPolicy{{
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: "X-Session-ID"},
+			}, {
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: "X-User-ID"},
+			}},
+		}),
+	}, {
+		name: "query parameter hash",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:          "RequestHash",
+			HashPolicyQueryKeyPrefix + "doo": "session_id",
+		},
+		paths: gooAndDoo,
+		want: withLoadBalancerPolicy(&v1.LoadBalancerPolicy{
+			Strategy: "RequestHash",
+			RequestHashPolicies: []v1.RequestHashPolicy{{
+				QueryParameterHashOptions: &v1.QueryParameterHashOptions{ParameterName: "session_id"},
+			}},
+		}),
+	}, {
+		name: "header then query parameter hash",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo":   "X-Session-ID",
+			HashPolicyQueryKeyPrefix + "goo":    "session_id",
+			HashPolicyTerminalKeyPrefix + "goo": "true",
+		},
+		paths: gooAndDoo,
+		want: withLoadBalancerPolicy(&v1.LoadBalancerPolicy{
+			Strategy: "RequestHash",
+			RequestHashPolicies: []v1.RequestHashPolicy{{
+				Terminal:          true,
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: "X-Session-ID"},
+			}, {
+				Terminal:                  true,
+				QueryParameterHashOptions: &v1.QueryParameterHashOptions{ParameterName: "session_id"},
+			}},
+		}),
+	}, {
+		name: "empty query parameter",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:          "RequestHash",
+			HashPolicyQueryKeyPrefix + "goo": "",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "query parameters",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:          "RequestHash",
+			HashPolicyQueryKeyPrefix + "goo": "session_id,user_id",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "query parameter needing escaping",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:          "RequestHash",
+			HashPolicyQueryKeyPrefix + "goo": "session id",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "source IP hash",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicySourceIPKeyPrefix + "goo": "true",
+		},
+		paths: gooAndDoo,
+		want: withLoadBalancerPolicy(&v1.LoadBalancerPolicy{
+			Strategy:            "RequestHash",
+			RequestHashPolicies: []v1.RequestHashPolicy{{HashSourceIP: true}},
+		}),
+	}, {
+		name: "source IP hash disabled",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicySourceIPKeyPrefix + "goo": "false",
+		},
+		paths: gooAndDoo,
+		want:  withLoadBalancerPolicy(&v1.LoadBalancerPolicy{Strategy: "RequestHash"}),
+	}, {
+		name: "source IP hash combined with other policies",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo":   "X-Session-ID",
+			HashPolicySourceIPKeyPrefix + "goo": "true",
+			HashPolicyQueryKeyPrefix + "doo":    "session_id",
+		},
+		paths: gooAndDoo,
+		want: withLoadBalancerPolicy(&v1.LoadBalancerPolicy{
+			Strategy: "RequestHash",
+			RequestHashPolicies: []v1.RequestHashPolicy{{
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: "X-Session-ID"},
+			}, {
+				HashSourceIP: true,
+			}, {
+				QueryParameterHashOptions: &v1.QueryParameterHashOptions{ParameterName: "session_id"},
+			}},
+		}),
+	}, {
+		name: "bad source IP hash",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicySourceIPKeyPrefix + "goo": "always",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "terminal header hash",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo":   "X-Session-ID",
+			HashPolicyTerminalKeyPrefix + "goo": "true",
+			HashPolicyHeaderKeyPrefix + "doo":   "X-User-ID",
+		},
+		paths: gooAndDoo,
+		want: withLoadBalancerPolicy(&v1.LoadBalancerPolicy{
+			Strategy: "RequestHash",
+			RequestHashPolicies: []v1.RequestHashPolicy{{
+				Terminal:          true,
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: "X-Session-ID"},
+			}, {
+				HeaderHashOptions: &v1.HeaderHashOptions{HeaderName: "X-User-ID"},
+			}},
+		}),
+	}, {
+		name: "terminal with a single hash policy",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo":   "X-Session-ID",
+			HashPolicyTerminalKeyPrefix + "goo": "true",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "terminal without a hash policy",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo":   "X-Session-ID",
+			HashPolicyTerminalKeyPrefix + "doo": "true",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "bad terminal",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:             "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo":   "X-Session-ID",
+			HashPolicyTerminalKeyPrefix + "goo": "yes please",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "header hash without strategy",
+		annotations: map[string]string{
+			HashPolicyHeaderKeyPrefix + "goo": "X-Session-ID",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "header hash with other strategy",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:           "Cookie",
+			HashPolicyHeaderKeyPrefix + "goo": "X-Session-ID",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}, {
+		name: "empty header",
+		annotations: map[string]string{
+			LoadBalancerStrategyKey:           "RequestHash",
+			HashPolicyHeaderKeyPrefix + "goo": "",
+		},
+		paths:   gooAndDoo,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want, err := makeTestProxies(t, makeTestIngress(nil, test.paths...), nil)
+			if err != nil {
+				t.Fatal("MakeHTTPProxies() without annotations =", err)
+			}
+			got, err := makeTestProxies(t, makeTestIngress(test.annotations, test.paths...), test.modifyConfig)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("MakeHTTPProxies() = %v, wantErr = %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if test.want != nil {
+				for _, proxy := range want {
+					test.want(proxy)
+				}
+			}
+			if !cmp.Equal(want, got) {
+				t.Error("MakeHTTPProxies (-want, +got) =", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+// makeTestIngress returns an Ingress with a single external rule for
+// example.com serving the given paths, or a single split to "goo" when no
+// paths are given.
+func makeTestIngress(annotations map[string]string, paths ...v1alpha1.HTTPIngressPath) *v1alpha1.Ingress {
+	if len(paths) == 0 {
+		paths = []v1alpha1.HTTPIngressPath{{
+			Splits: []v1alpha1.IngressBackendSplit{{
+				IngressBackend: v1alpha1.IngressBackend{
+					ServiceName: "goo",
+					ServicePort: intstr.FromInt(123),
+				},
+				Percent: 100,
+			}},
+		}}
+	}
+	return &v1alpha1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "foo",
+			Name:        "bar",
+			Annotations: annotations,
+		},
+		Spec: v1alpha1.IngressSpec{
+			HTTPOption: v1alpha1.HTTPOptionEnabled,
+			Rules: []v1alpha1.IngressRule{{
+				Hosts:      []string{"example.com"},
+				Visibility: v1alpha1.IngressVisibilityExternalIP,
+				HTTP: &v1alpha1.HTTPIngressRuleValue{
+					Paths: paths,
+				},
+			}},
+		},
+	}
+}
+
+// makeTestProxies runs MakeHTTPProxies against the given Ingress with the
+// default test configuration, after applying modifyConfig to it when set.
+// As in TestMakeProxies, the "goo" service speaks h2c.
+func makeTestProxies(t *testing.T, ing *v1alpha1.Ingress, modifyConfig func(*config.Config)) ([]*v1.HTTPProxy, error) {
+	t.Helper()
+	cfg := &config.Config{
+		Contour: &config.Contour{
+			VisibilityClasses: map[v1alpha1.IngressVisibility]string{
+				v1alpha1.IngressVisibilityClusterLocal: privateClass,
+				v1alpha1.IngressVisibilityExternalIP:   publicClass,
+			},
+			TimeoutPolicyResponse: "infinity",
+			TimeoutPolicyIdle:     "infinity",
+		},
+	}
+	if modifyConfig != nil {
+		modifyConfig(cfg)
+	}
+	tcs := &testConfigStore{config: cfg}
+	return MakeHTTPProxies(tcs.ToContext(context.Background()), ing, map[string]string{
+		"goo": "h2c",
+	})
+}
+
+type testConfigStore struct {
+	config *config.Config
+}
+
+func (t *testConfigStore) ToContext(ctx context.Context) context.Context {
+	return config.ToContext(ctx, t.config)
+}
+
+var _ reconciler.ConfigStore = (*testConfigStore)(nil)
+
+func TestAggregateProxyStatuses(t *testing.T) {
+	proxy := func(name string, conds ...v1.DetailedCondition) *v1.HTTPProxy {
+		return &v1.HTTPProxy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Generation: 2},
+			Status:     v1.HTTPProxyStatus{Conditions: conds},
+		}
+	}
+	valid := v1.DetailedCondition{
+		Condition: v1.Condition{Type: v1.ValidConditionType, Status: v1.ConditionTrue, ObservedGeneration: 2},
+	}
+	invalid := func(messages ...string) v1.DetailedCondition {
+		cond := valid
+		for _, message := range messages {
+			cond.AddError(v1.ConditionTypeRouteError, "Invalid", message)
+		}
+		return cond
+	}
+	stale := func(cond v1.DetailedCondition) v1.DetailedCondition {
+		cond.ObservedGeneration = 1
+		return cond
+	}
+
+	tests := []struct {
+		name        string
+		proxies     []*v1.HTTPProxy
+		wantReady   bool
+		wantMessage string
+	}{{
+		name:      "no proxies",
+		wantReady: true,
+	}, {
+		name:      "all valid",
+		proxies:   []*v1.HTTPProxy{proxy("a", valid), proxy("b", valid)},
+		wantReady: true,
+	}, {
+		name:    "not yet processed",
+		proxies: []*v1.HTTPProxy{proxy("a", valid), proxy("b")},
+	}, {
+		name:        "one invalid",
+		proxies:     []*v1.HTTPProxy{proxy("a", valid), proxy("b", invalid("bad route"))},
+		wantMessage: `HTTPProxy "b" is invalid: bad route`,
+	}, {
+		name:        "first invalid wins",
+		proxies:     []*v1.HTTPProxy{proxy("a"), proxy("b", invalid("first", "second")), proxy("c", invalid("third"))},
+		wantMessage: `HTTPProxy "b" is invalid: first`,
+	}, {
+		name:    "valid for an earlier generation",
+		proxies: []*v1.HTTPProxy{proxy("a", valid), proxy("b", stale(valid))},
+	}, {
+		name:    "invalid for an earlier generation",
+		proxies: []*v1.HTTPProxy{proxy("a", valid), proxy("b", stale(invalid("fixed since")))},
+	}, {
+		name:        "invalid after an earlier stale one",
+		proxies:     []*v1.HTTPProxy{proxy("a", stale(invalid("fixed since"))), proxy("b", invalid("bad route"))},
+		wantMessage: `HTTPProxy "b" is invalid: bad route`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready, message := AggregateProxyStatuses(test.proxies)
+			if ready != test.wantReady || message != test.wantMessage {
+				t.Errorf("AggregateProxyStatuses() = (%v, %q), wanted (%v, %q)", ready, message, test.wantReady, test.wantMessage)
+			}
+		})
+	}
+}
@@ -22,3 +22,9 @@ import "knative.dev/pkg/kmeta"
 func EndpointProbeIngress(ing kmeta.Accessor) string {
 	return kmeta.ChildName(ing.GetName()+"--", "ep")
 }
+
+// CertificateDelegation returns the name for the TLSCertificateDelegation that
+// delegates the named secret to the namespace of the given kingress.
+func CertificateDelegation(ing kmeta.Accessor, secretName string) string {
+	return kmeta.ChildName(ing.GetName()+"--"+ing.GetNamespace()+"--", secretName)
+}
@@ -18,13 +18,17 @@ package contour
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 
+	v1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	fakecontourclientset "knative.dev/net-contour/pkg/client/clientset/versioned/fake"
 	fakecontourclient "knative.dev/net-contour/pkg/client/injection/client/fake"
 	fakenetworkingclient "knative.dev/networking/pkg/client/injection/client/fake"
 	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
 	"knative.dev/pkg/reconciler"
 
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ktesting "k8s.io/client-go/testing"
@@ -64,6 +68,7 @@ func MakeFactory(ctor Ctor) rtesting.Factory {
 		rtesting.PrependGenerateNameReactor(&client.Fake)
 		rtesting.PrependGenerateNameReactor(&contourclient.Fake)
 		rtesting.PrependGenerateNameReactor(&kubeclient.Fake)
+		PrependHTTPProxyApplyReactor(contourclient)
 
 		// Set up our Controller from the fakes.
 		c := ctor(ctx, &ls, configmap.NewStaticWatcher())
@@ -97,3 +102,40 @@ func MakeFactory(ctor Ctor) rtesting.Factory {
 		return c, actionRecorderList, eventList
 	}
 }
+
+// PrependHTTPProxyApplyReactor handles server-side apply patches of
+// HTTPProxies, which the fake object tracker does not support, by storing the
+// applied object in place of any existing one, keeping its status. New
+// proxies are marked valid, as Contour would once it accepts them.
+func PrependHTTPProxyApplyReactor(client *fakecontourclientset.Clientset) {
+	client.PrependReactor("patch", "httpproxies", func(action ktesting.Action) (bool, runtime.Object, error) {
+		patch := action.(ktesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		proxy := &v1.HTTPProxy{}
+		if err := json.Unmarshal(patch.GetPatch(), proxy); err != nil {
+			return true, nil, err
+		}
+		gvr := action.GetResource()
+		existing, err := client.Tracker().Get(gvr, patch.GetNamespace(), patch.GetName())
+		switch {
+		case apierrs.IsNotFound(err):
+			proxy.Status.Conditions = []v1.DetailedCondition{{
+				Condition: v1.Condition{
+					Type:               v1.ValidConditionType,
+					Status:             v1.ConditionTrue,
+					ObservedGeneration: proxy.Generation,
+				},
+			}}
+			err = client.Tracker().Create(gvr, proxy, patch.GetNamespace())
+		case err == nil:
+			proxy.Status = existing.(*v1.HTTPProxy).Status
+			err = client.Tracker().Update(gvr, proxy, patch.GetNamespace())
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		return true, proxy, nil
+	})
+}
@@ -93,6 +93,11 @@ func (l *Listers) GetHTTPProxyLister() contourlisters.HTTPProxyLister {
 	return contourlisters.NewHTTPProxyLister(l.IndexerFor(&contour.HTTPProxy{}))
 }
 
+// GetTLSCertificateDelegationLister get lister for TLSCertificateDelegation resource.
+func (l *Listers) GetTLSCertificateDelegationLister() contourlisters.TLSCertificateDelegationLister {
+	return contourlisters.NewTLSCertificateDelegationLister(l.IndexerFor(&contour.TLSCertificateDelegation{}))
+}
+
 // GetK8sServiceLister get lister for K8s Service resource.
 func (l *Listers) GetK8sServiceLister() corev1listers.ServiceLister {
 	return corev1listers.NewServiceLister(l.IndexerFor(&corev1.Service{}))